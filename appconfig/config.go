@@ -2,40 +2,258 @@ package appconfig
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // AppConfig contains config settings
 type AppConfig struct {
-	Debug       bool   `bson:"Debug"`
-	ResultsColl string `bson:"ResultsColl"`
-	Connections int    `bson:"Connections"`
-	GoRoutines  int    `bson:"GoRoutines"`
-	Profiles    int    `bson:"Profiles"` //Must be divisible by (Connections * GoRoutines)
-	TestRuns    int    `bson:"TestRuns"` //Must be divisible by (Connections * GoRoutines)
-	ReloadData  bool   `bson:"ReloadData"`
-	RunTests    bool   `bson:"RunTests"`
+	MongoDBURI              string             `bson:"-" env:"MONGODB_URI" flag:"uri"` //Never stored, as it may contain credentials
+	DBName                  string             `bson:"DBName" env:"MONGODB_DB_NAME" flag:"db"`
+	ConfigColl              string             `bson:"-" env:"MONGODB_CONFIG_COLL" flag:"config-coll"`    //Optional collection holding the config documents
+	ConfigName              string             `bson:"Name" env:"MONGODB_CONFIG_NAME" flag:"config-name"` //Name of the config document to use, if the collection holds several
+	Debug                   bool               `bson:"Debug"`
+	ResultsColl             string             `bson:"ResultsColl"`
+	Connections             int                `bson:"Connections"`
+	GoRoutines              int                `bson:"GoRoutines"`
+	Profiles                int                `bson:"Profiles"`
+	TestRuns                int                `bson:"TestRuns"`
+	ReloadData              bool               `bson:"ReloadData"`
+	RunTests                bool               `bson:"RunTests"`
+	PipelineDir             string             `bson:"PipelineDir"`             //Optional directory of Extended JSON pipeline definitions to test
+	SeparateInstanceResults bool               `bson:"SeparateInstanceResults"` //Write each iteration result to its own document in <ResultsColl>_instances
+	BaselineRunID           string             `bson:"BaselineRunID"`           //Optional run to compare test results against, failing the run if performance regresses
+	RegressionThreshold     float64            `bson:"RegressionThreshold"`     //Percentage change in throughput or P95 treated as a regression
+	InstallConfigValidator  bool               `bson:"InstallConfigValidator"`  //Install a $jsonSchema validator on the config collection
+	OperationTimeoutSecs    int                `bson:"OperationTimeoutSecs"`    //Seconds a single database operation may take before it is abandoned
+	ResumeLoad              bool               `bson:"ResumeLoad"`              //Continue an unfinished data load from its checkpoints rather than starting again
+	Seed                    int64              `bson:"Seed"`                    //Seed for the generated data and test parameters. Zero for a different seed on every run
+	ParameterFile           string             `bson:"ParameterFile"`           //Optional JSON file the test parameter schedule is replayed from, or saved to if it doesn't exist
+	FamilySize              *Range             `bson:"FamilySize"`              //Profiles in each generated family. Nil for the default range
+	SharedDevices           *Range             `bson:"SharedDevices"`           //Devices shared by each generated family. Nil for the default range
+	PersonalDevices         *Range             `bson:"PersonalDevices"`         //Devices belonging to each generated profile. Nil for the default range
+	InsertBatchSize         int                `bson:"InsertBatchSize"`         //Documents written to a collection in each InsertMany
+	SchemaVariant           string             `bson:"SchemaVariant"`           //Data model the profiles and devices are generated in
+	DeviceBucketSize        int                `bson:"DeviceBucketSize"`        //Devices held in each DeviceBuckets document by the bucketed schema variant
+	Distributions           DistributionConfig `bson:"Distributions"`           //How the values of generated fields are distributed
+	DateRangeDays           int                `bson:"DateRangeDays"`           //Days covered by the date-driven pipelines, which are only tested when this is set
+	ExportDir               string             `bson:"ExportDir"`               //Directory export mode writes the generated data set to
+	ExportFormat            string             `bson:"ExportFormat"`            //Format of the exported data set - json, bson, csv or discard
+}
+
+// Range is an inclusive range of counts. Range settings are pointers, so that an explicit range of 0 to 0 can
+// be told apart from a setting that was not given, which takes the default range.
+type Range struct {
+	Min int `bson:"Min"`
+	Max int `bson:"Max"`
+}
+
+// orDefault returns the range, or def if the range has not been set.
+func (r *Range) orDefault(def Range) Range {
+	if r == nil {
+		return def
+	}
+	return *r
+}
+
+// The default ranges of the generated counts, and the default batch size for writes.
+var (
+	DefaultFamilySize      = Range{Min: 1, Max: 6}
+	DefaultSharedDevices   = Range{Min: 1, Max: 5}
+	DefaultPersonalDevices = Range{Min: 0, Max: 4}
+)
+
+const DefaultInsertBatchSize = 10000
+
+// FamilySizeRange returns the range of the number of profiles in a generated family.
+func (c AppConfig) FamilySizeRange() Range {
+	return c.FamilySize.orDefault(DefaultFamilySize)
+}
+
+// SharedDevicesRange returns the range of the number of devices shared by a generated family.
+func (c AppConfig) SharedDevicesRange() Range {
+	return c.SharedDevices.orDefault(DefaultSharedDevices)
+}
+
+// PersonalDevicesRange returns the range of the number of devices belonging to a generated profile.
+func (c AppConfig) PersonalDevicesRange() Range {
+	return c.PersonalDevices.orDefault(DefaultPersonalDevices)
+}
+
+// InsertBatch returns the number of documents written to a collection in each InsertMany.
+func (c AppConfig) InsertBatch() int {
+	if c.InsertBatchSize == 0 {
+		return DefaultInsertBatchSize
+	}
+	return c.InsertBatchSize
+}
+
+// The schema variants the data can be generated in. Each models a profile's devices differently.
+const (
+	SchemaSuperset          = "superset"          //Every model at once - the profile references its devices, which are also mapped
+	SchemaNormalized        = "normalized"        //Profiles and devices are only linked by the Mappings collection
+	SchemaReferenced        = "referenced"        //Profiles hold an array of their devices' serial numbers
+	SchemaExtendedReference = "extendedReference" //Profiles hold the serial number and name of each of their devices
+	SchemaEmbedded          = "embedded"          //Profiles embed their full device documents
+	SchemaBucketed          = "bucketed"          //Each profile's devices are held in buckets in the DeviceBuckets collection
+)
+
+// SchemaVariants lists the valid SchemaVariant settings.
+var SchemaVariants = []string{SchemaSuperset, SchemaNormalized, SchemaReferenced, SchemaExtendedReference, SchemaEmbedded, SchemaBucketed}
+
+// DefaultDeviceBucketSize applies when DeviceBucketSize is not set.
+const DefaultDeviceBucketSize = 50
+
+// Schema returns the schema variant the data is generated in.
+func (c AppConfig) Schema() string {
+	if c.SchemaVariant == "" {
+		return SchemaSuperset
+	}
+	return c.SchemaVariant
+}
+
+// DeviceBucket returns the number of devices held in each DeviceBuckets document.
+func (c AppConfig) DeviceBucket() int {
+	if c.DeviceBucketSize == 0 {
+		return DefaultDeviceBucketSize
+	}
+	return c.DeviceBucketSize
+}
+
+// DistributionConfig sets how the generated data and test parameters are distributed between their possible
+// values. Fields that are not set are distributed uniformly.
+type DistributionConfig struct {
+	City            Distribution `bson:"City,omitempty" json:",omitempty"`
+	DeviceName      Distribution `bson:"DeviceName,omitempty" json:",omitempty"`
+	FamilySize      Distribution `bson:"FamilySize,omitempty" json:",omitempty"`
+	SharedDevices   Distribution `bson:"SharedDevices,omitempty" json:",omitempty"`   //Devices shared by a family
+	PersonalDevices Distribution `bson:"PersonalDevices,omitempty" json:",omitempty"` //Devices belonging to a single profile
+}
+
+// Distribution describes how a generated field's values are picked from its list of possible values.
+type Distribution struct {
+	Type     string             `bson:"Type,omitempty" json:",omitempty"`     //uniform, zipf, weighted or table
+	Exponent float64            `bson:"Exponent,omitempty" json:",omitempty"` //zipf: how steeply popularity falls with rank
+	Weights  map[string]float64 `bson:"Weights,omitempty" json:",omitempty"`  //weighted: relative frequencies, 1 for any value not listed
+	File     string             `bson:"File,omitempty" json:",omitempty"`     //table: CSV file of value,frequency rows, 0 for any value not listed
+}
+
+// DefaultOperationTimeout applies when OperationTimeoutSecs is not set.
+const DefaultOperationTimeout = 5 * time.Minute
+
+// OperationTimeout returns the time a single database operation may take before it is abandoned.
+func (c AppConfig) OperationTimeout() time.Duration {
+	if c.OperationTimeoutSecs == 0 {
+		return DefaultOperationTimeout
+	}
+	return time.Duration(c.OperationTimeoutSecs) * time.Second
+}
+
+// DefaultExportDir and DefaultExportFormat apply when ExportDir and ExportFormat are not set.
+const (
+	DefaultExportDir    = "export"
+	DefaultExportFormat = "json"
+)
+
+// ExportPath returns the directory export mode writes the generated collections to - a directory named after
+// the database in ExportDir, as laid out by mongodump.
+func (c AppConfig) ExportPath() string {
+	dir := c.ExportDir
+	if dir == "" {
+		dir = DefaultExportDir
+	}
+	return filepath.Join(dir, c.DBName)
+}
+
+// ExportFileFormat returns the format of the files written by export mode.
+func (c AppConfig) ExportFileFormat() string {
+	if c.ExportFormat == "" {
+		return DefaultExportFormat
+	}
+	return c.ExportFormat
+}
+
+// InstanceResultsColl returns the name of the collection iteration results are written to when SeparateInstanceResults is set.
+func (c AppConfig) InstanceResultsColl() string {
+	return c.ResultsColl + "_instances"
 }
 
-// ConfigData contains application configuration settings read from a JSON formatted file.
+// ConfigData contains the effective application configuration settings, merged from each configuration source.
 var ConfigData AppConfig
 
-func ReadConfigMDB(mongoDB *mongo.Database, configColl string) (configjson string, err error) {
+// ReadConfigMDB reads the config document from MongoDB, applies the settings from the configuration file,
+// environment variables and command line flags over it (see Load) and validates the result.
+func ReadConfigMDB(ctx context.Context, mongoDB *mongo.Database, configColl string) (configjson string, err error) {
 
-	//read the config data from MongoDB
+	ctx, cancel := context.WithTimeout(ctx, ConfigData.OperationTimeout())
+	defer cancel()
+	//read the config data from MongoDB - the named document if a config name was given, otherwise any document
+	var cfg AppConfig
 	coll := mongoDB.Collection(configColl)
-	filter := bson.D{{}}
-	err = coll.FindOne(context.TODO(), filter).Decode(&ConfigData)
+	filter := bson.D{}
+	if ConfigData.ConfigName != "" {
+		filter = bson.D{{"Name", ConfigData.ConfigName}}
+	} else {
+		count, countErr := coll.CountDocuments(ctx, filter)
+		if countErr != nil {
+			return "", fmt.Errorf("failed to count config documents in %s: %w", configColl, countErr)
+		}
+		if count > 1 {
+			return "", fmt.Errorf("%s holds %d config documents - choose one with MONGODB_CONFIG_NAME or -config-name (see list-configs)", configColl, count)
+		}
+	}
+	err = coll.FindOne(ctx, filter).Decode(&cfg)
+	if errors.Is(err, mongo.ErrNoDocuments) && ConfigData.ConfigName != "" {
+		return "", fmt.Errorf("no config document named %q in %s - use list-configs to see the available names", ConfigData.ConfigName, configColl)
+	}
 	if err != nil {
-		log.Fatalf("Error reading config info from MongoDB: %s", err)
+		return "", fmt.Errorf("error reading config info from MongoDB: %w", err)
 	}
-	if jbytes, err := json.Marshal(ConfigData); err != nil {
+	//The document is the lowest precedence source of settings
+	if err = applyOverrides(&cfg); err != nil {
 		return "", err
-	} else {
-		return string(jbytes), err
 	}
+	if err = cfg.Validate(); err != nil {
+		return "", err
+	}
+	ConfigData = cfg
+	if ConfigData.InstallConfigValidator {
+		if err = InstallConfigValidator(ctx, mongoDB, configColl); err != nil {
+			return "", err
+		}
+	}
+	return EffectiveJSON()
+}
+
+// ConfigSummary identifies a config document in the config collection.
+type ConfigSummary struct {
+	Name        string `bson:"Name"`
+	DBName      string `bson:"DBName"`
+	Connections int    `bson:"Connections"`
+	GoRoutines  int    `bson:"GoRoutines"`
+	Profiles    int    `bson:"Profiles"`
+	TestRuns    int    `bson:"TestRuns"`
+}
+
+// ListConfigs returns a summary of each config document in the config collection, ordered by name.
+func ListConfigs(ctx context.Context, mongoDB *mongo.Database, configColl string) ([]ConfigSummary, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, ConfigData.OperationTimeout())
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{"Name", 1}})
+	cursor, err := mongoDB.Collection(configColl).Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config documents from %s: %w", configColl, err)
+	}
+	var configs []ConfigSummary
+	if err = cursor.All(ctx, &configs); err != nil {
+		return nil, fmt.Errorf("error decoding config documents from %s: %w", configColl, err)
+	}
+	return configs, nil
 }
@@ -0,0 +1,198 @@
+package appconfig
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Args holds the command line arguments remaining once the flags have been parsed, e.g. the program mode.
+var Args []string
+
+// override sets a single AppConfig field from one of the configuration layers.
+type override func(cfg *AppConfig) error
+
+// overrides holds the config file, environment variable and command line flag settings, in increasing
+// order of precedence. They are applied over the MongoDB config document when it is read.
+var overrides []override
+
+// Load builds ConfigData from a JSON configuration file, environment variables and command line flags, each of
+// which can set any AppConfig field. A later source overrides an earlier one. If ConfigColl is set, ReadConfigMDB
+// must then be called to merge in the MongoDB config document, beneath all of these sources.
+//
+// Each field can be set with an environment variable named PIPELINE_ followed by the field name in upper snake
+// case (e.g. PIPELINE_RESULTS_COLL) and a flag named after the field in lower kebab case (e.g. -results-coll),
+// unless the field's env or flag struct tag says otherwise.
+func Load(args []string) error {
+
+	fs := flag.NewFlagSet("pipeline_blog", flag.ContinueOnError)
+	configFile := fs.String("config-file", os.Getenv("PIPELINE_CONFIG_FILE"), "JSON file supplying config settings")
+	var flagOverrides []override
+	configType := reflect.TypeOf(AppConfig{})
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		value := &flagValue{field: field, isBool: field.Type.Kind() == reflect.Bool}
+		fs.Var(value, flagName(field), flagUsage(field))
+		flagOverrides = append(flagOverrides, value.apply)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	Args = fs.Args()
+
+	overrides = nil
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+		overrides = append(overrides, func(cfg *AppConfig) error {
+			if err := json.Unmarshal(data, cfg); err != nil {
+				return fmt.Errorf("config file %s: %w", *configFile, err)
+			}
+			return nil
+		})
+	}
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		if value, ok := os.LookupEnv(envName(field)); ok {
+			overrides = append(overrides, func(cfg *AppConfig) error {
+				return setField(cfg, field, value, envName(field))
+			})
+		}
+	}
+	overrides = append(overrides, flagOverrides...)
+
+	var cfg AppConfig
+	if err := applyOverrides(&cfg); err != nil {
+		return err
+	}
+	ConfigData = cfg
+	return nil
+}
+
+func applyOverrides(cfg *AppConfig) error {
+	for _, apply := range overrides {
+		if err := apply(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flagValue records a command line flag so it can be applied once the lower precedence layers have been read.
+type flagValue struct {
+	field  reflect.StructField
+	isBool bool
+	value  string
+	set    bool
+}
+
+func (f *flagValue) String() string {
+	return f.value
+}
+
+func (f *flagValue) Set(value string) error {
+	f.value, f.set = value, true
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+func (f *flagValue) apply(cfg *AppConfig) error {
+	if !f.set {
+		return nil
+	}
+	return setField(cfg, f.field, f.value, "-"+flagName(f.field))
+}
+
+// setField parses a string setting into a field. Settings for fields that are not a simple type are parsed as JSON.
+func setField(cfg *AppConfig, field reflect.StructField, value, source string) error {
+
+	target := reflect.ValueOf(cfg).Elem().FieldByIndex(field.Index)
+	var err error
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(value)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		target.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(value, 10, 64)
+		target.SetInt(n)
+	case reflect.Float64:
+		var n float64
+		n, err = strconv.ParseFloat(value, 64)
+		target.SetFloat(n)
+	default:
+		err = json.Unmarshal([]byte(value), target.Addr().Interface())
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s: %w", value, source, err)
+	}
+	return nil
+}
+
+func flagUsage(field reflect.StructField) string {
+	switch field.Type.Kind() {
+	case reflect.Bool:
+		return fmt.Sprintf("sets %s (env %s)", field.Name, envName(field))
+	case reflect.String, reflect.Int, reflect.Int64, reflect.Float64:
+		return fmt.Sprintf("sets %s to the given `%s` (env %s)", field.Name, field.Type.Kind(), envName(field))
+	default:
+		return fmt.Sprintf("sets %s from the given `json` (env %s)", field.Name, envName(field))
+	}
+}
+
+func envName(field reflect.StructField) string {
+	if name := field.Tag.Get("env"); name != "" {
+		return name
+	}
+	return "PIPELINE_" + strings.ToUpper(strings.Join(splitWords(field.Name), "_"))
+}
+
+func flagName(field reflect.StructField) string {
+	if name := field.Tag.Get("flag"); name != "" {
+		return name
+	}
+	return strings.ToLower(strings.Join(splitWords(field.Name), "-"))
+}
+
+// splitWords splits a Go identifier into words, keeping acronyms together e.g. BaselineRunID -> Baseline Run ID.
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+		nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if unicode.IsUpper(runes[i]) && (prevLower || nextLower) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+var uriPassword = regexp.MustCompile(`(://[^:/@]*:)[^@/]*@`)
+
+// EffectiveJSON returns the merged configuration as JSON, with any password in the connection URI masked.
+func EffectiveJSON() (string, error) {
+	cfg := ConfigData
+	cfg.MongoDBURI = uriPassword.ReplaceAllString(cfg.MongoDBURI, "${1}*****@")
+	jbytes, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(jbytes), nil
+}
@@ -0,0 +1,116 @@
+package appconfig
+
+import (
+	"reflect"
+	"testing"
+)
+
+// configField returns the AppConfig field with the given name.
+func configField(t *testing.T, name string) reflect.StructField {
+	t.Helper()
+	field, ok := reflect.TypeOf(AppConfig{}).FieldByName(name)
+	if !ok {
+		t.Fatalf("AppConfig has no field %s", name)
+	}
+	return field
+}
+
+func TestSplitWords(t *testing.T) {
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"Profiles", []string{"Profiles"}},
+		{"GoRoutines", []string{"Go", "Routines"}},
+		{"BaselineRunID", []string{"Baseline", "Run", "ID"}},
+		{"MongoDBURI", []string{"Mongo", "DBURI"}},
+		{"DBName", []string{"DB", "Name"}},
+		{"IDName", []string{"ID", "Name"}},
+		{"Ipv6Address", []string{"Ipv6", "Address"}},
+	}
+	for _, test := range tests {
+		if got := splitWords(test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSettingNames(t *testing.T) {
+
+	tests := []struct {
+		field, env, flag string
+	}{
+		{"ResultsColl", "PIPELINE_RESULTS_COLL", "results-coll"},
+		{"BaselineRunID", "PIPELINE_BASELINE_RUN_ID", "baseline-run-id"},
+		{"OperationTimeoutSecs", "PIPELINE_OPERATION_TIMEOUT_SECS", "operation-timeout-secs"},
+		//Tags override the generated names
+		{"MongoDBURI", "MONGODB_URI", "uri"},
+		{"DBName", "MONGODB_DB_NAME", "db"},
+	}
+	for _, test := range tests {
+		field := configField(t, test.field)
+		if got := envName(field); got != test.env {
+			t.Errorf("envName(%s) = %q, want %q", test.field, got, test.env)
+		}
+		if got := flagName(field); got != test.flag {
+			t.Errorf("flagName(%s) = %q, want %q", test.field, got, test.flag)
+		}
+	}
+}
+
+func TestSetField(t *testing.T) {
+
+	tests := []struct {
+		field, value string
+		want         AppConfig
+		wantErr      bool
+	}{
+		{field: "ResultsColl", value: "Results", want: AppConfig{ResultsColl: "Results"}},
+		{field: "RunTests", value: "true", want: AppConfig{RunTests: true}},
+		{field: "Connections", value: "3", want: AppConfig{Connections: 3}},
+		{field: "Seed", value: "-42", want: AppConfig{Seed: -42}},
+		{field: "RegressionThreshold", value: "2.5", want: AppConfig{RegressionThreshold: 2.5}},
+		//Other types are parsed as JSON
+		{field: "FamilySize", value: `{"Min": 2, "Max": 4}`, want: AppConfig{FamilySize: &Range{Min: 2, Max: 4}}},
+		//An explicit range of 0 to 0 is kept, rather than being treated as unset
+		{field: "SharedDevices", value: `{"Min": 0, "Max": 0}`, want: AppConfig{SharedDevices: &Range{}}},
+		{field: "RunTests", value: "yes", wantErr: true},
+		{field: "Connections", value: "3.5", wantErr: true},
+		{field: "FamilySize", value: "2-4", wantErr: true},
+	}
+	for _, test := range tests {
+		var cfg AppConfig
+		err := setField(&cfg, configField(t, test.field), test.value, "test")
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("setField(%s, %q) succeeded, want an error", test.field, test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("setField(%s, %q) failed: %v", test.field, test.value, err)
+		} else if !reflect.DeepEqual(cfg, test.want) {
+			t.Errorf("setField(%s, %q) = %+v, want %+v", test.field, test.value, cfg, test.want)
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+
+	defer func() { ConfigData, overrides, Args = AppConfig{}, nil, nil }()
+	t.Setenv("PIPELINE_CONNECTIONS", "2")
+	t.Setenv("PIPELINE_GO_ROUTINES", "4")
+	t.Setenv("MONGODB_DB_NAME", "fromEnv")
+
+	//The flag overrides the environment variable, which applies where no flag is given
+	if err := Load([]string{"-connections", "5", "-run-tests", "export"}); err != nil {
+		t.Fatal(err)
+	}
+	if ConfigData.Connections != 5 || ConfigData.GoRoutines != 4 || ConfigData.DBName != "fromEnv" || !ConfigData.RunTests {
+		t.Errorf("Load() = %+v", ConfigData)
+	}
+	if !reflect.DeepEqual(Args, []string{"export"}) {
+		t.Errorf("Load() left args %q, want [export]", Args)
+	}
+}
@@ -0,0 +1,248 @@
+package appconfig
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FieldError describes a problem with a single config setting.
+type FieldError struct {
+	Field   string
+	Problem string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Problem
+}
+
+// ValidationError lists every problem found with a configuration.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	problems := make([]string, len(e))
+	for i, fieldErr := range e {
+		problems[i] = fieldErr.Error()
+	}
+	return "invalid configuration:\n  " + strings.Join(problems, "\n  ")
+}
+
+// Validate checks the whole configuration, returning a ValidationError listing every problem found.
+func (c AppConfig) Validate() error {
+	return c.validate(false)
+}
+
+// ValidateExport checks the configuration for export mode. The data set is written to files, so the settings
+// for connecting to MongoDB and running the tests are not checked.
+func (c AppConfig) ValidateExport() error {
+	return c.validate(true)
+}
+
+func (c AppConfig) validate(exporting bool) error {
+
+	var problems ValidationError
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, FieldError{Field: field, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	if c.MongoDBURI == "" && !exporting {
+		add("MongoDBURI", "must be set")
+	}
+	if c.DBName == "" {
+		add("DBName", "must be set")
+	}
+	if c.ConfigName != "" && c.ConfigColl == "" {
+		add("ConfigName", "requires ConfigColl to be set")
+	}
+	if c.ResultsColl == "" && !exporting {
+		add("ResultsColl", "must not be empty")
+	}
+	if c.Connections < 1 {
+		add("Connections", "must be at least 1, got %d", c.Connections)
+	}
+	if c.GoRoutines < 1 {
+		add("GoRoutines", "must be at least 1, got %d", c.GoRoutines)
+	}
+	if c.ReloadData && c.Profiles < 1 {
+		add("Profiles", "must be at least 1 when ReloadData is set, got %d", c.Profiles)
+	} else if exporting && c.Profiles < 1 {
+		add("Profiles", "must be at least 1 to export data, got %d", c.Profiles)
+	}
+	if c.RunTests && c.TestRuns < 1 {
+		add("TestRuns", "must be at least 1 when RunTests is set, got %d", c.TestRuns)
+	}
+	if c.PipelineDir != "" {
+		if info, err := os.Stat(c.PipelineDir); err != nil {
+			add("PipelineDir", "%v", err)
+		} else if !info.IsDir() {
+			add("PipelineDir", "%s is not a directory", c.PipelineDir)
+		}
+	}
+	if c.RegressionThreshold < 0 {
+		add("RegressionThreshold", "must not be negative, got %g", c.RegressionThreshold)
+	}
+	if c.ResumeLoad && exporting {
+		add("ResumeLoad", "is not supported when exporting data")
+	} else if c.ResumeLoad && !c.ReloadData {
+		add("ResumeLoad", "requires ReloadData to be set")
+	}
+	switch c.ExportFormat {
+	case "", "json", "bson", "csv", "discard":
+	default:
+		add("ExportFormat", "must be json, bson, csv or discard, got %q", c.ExportFormat)
+	}
+	ranges := []struct {
+		name  string
+		r     *Range
+		least int
+	}{
+		{"FamilySize", c.FamilySize, 1}, {"SharedDevices", c.SharedDevices, 0}, {"PersonalDevices", c.PersonalDevices, 0},
+	}
+	for _, field := range ranges {
+		if field.r == nil {
+			continue
+		}
+		if field.r.Min < field.least {
+			add(field.name, "Min must be at least %d, got %d", field.least, field.r.Min)
+		}
+		if field.r.Max < field.r.Min {
+			add(field.name, "Max must not be less than Min, got Min %d and Max %d", field.r.Min, field.r.Max)
+		}
+	}
+	if c.InsertBatchSize < 0 {
+		add("InsertBatchSize", "must not be negative, got %d", c.InsertBatchSize)
+	}
+	if c.SchemaVariant != "" && !slices.Contains(SchemaVariants, c.SchemaVariant) {
+		add("SchemaVariant", "must be one of %s, got %q", strings.Join(SchemaVariants, ", "), c.SchemaVariant)
+	}
+	if c.DeviceBucketSize < 0 {
+		add("DeviceBucketSize", "must not be negative, got %d", c.DeviceBucketSize)
+	}
+	c.Distributions.validate(add)
+	if c.DateRangeDays < 0 {
+		add("DateRangeDays", "must not be negative, got %d", c.DateRangeDays)
+	}
+	if c.OperationTimeoutSecs < 0 {
+		add("OperationTimeoutSecs", "must not be negative, got %d", c.OperationTimeoutSecs)
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// validate checks the settings of each distribution. The value names and table files are checked when the
+// distributions are set up for the data generator.
+func (d DistributionConfig) validate(add func(field, format string, args ...interface{})) {
+
+	fields := []struct {
+		name string
+		dist Distribution
+	}{
+		{"City", d.City}, {"DeviceName", d.DeviceName}, {"FamilySize", d.FamilySize},
+		{"SharedDevices", d.SharedDevices}, {"PersonalDevices", d.PersonalDevices},
+	}
+	for _, field := range fields {
+		name := "Distributions." + field.name
+		switch field.dist.Type {
+		case "", "uniform":
+		case "zipf":
+			if field.dist.Exponent <= 0 {
+				add(name, "zipf distributions need an Exponent greater than 0, got %g", field.dist.Exponent)
+			}
+		case "weighted":
+			if len(field.dist.Weights) == 0 {
+				add(name, "weighted distributions need Weights")
+			}
+			for value, weight := range field.dist.Weights {
+				if weight < 0 {
+					add(name, "weight for %q must not be negative, got %g", value, weight)
+				}
+			}
+		case "table":
+			if field.dist.File == "" {
+				add(name, "table distributions need a File")
+			}
+		default:
+			add(name, "Type must be uniform, zipf, weighted or table, got %q", field.dist.Type)
+		}
+	}
+}
+
+// configSchema is the $jsonSchema validator installed on the config collection. It enforces the types and
+// ranges of the settings present in the document - checks involving more than one setting, and whether the
+// required settings are set by one of the configuration sources, are left to Validate.
+var configSchema = bson.D{
+	{"bsonType", "object"},
+	{"properties", bson.D{
+		{"Name", bson.D{{"bsonType", "string"}, {"minLength", 1}}},
+		{"DBName", bson.D{{"bsonType", "string"}, {"minLength", 1}}},
+		{"Debug", bson.D{{"bsonType", "bool"}}},
+		{"ResultsColl", bson.D{{"bsonType", "string"}, {"minLength", 1}}},
+		{"Connections", intSchema(1)},
+		{"GoRoutines", intSchema(1)},
+		{"Profiles", intSchema(0)},
+		{"TestRuns", intSchema(0)},
+		{"ReloadData", bson.D{{"bsonType", "bool"}}},
+		{"RunTests", bson.D{{"bsonType", "bool"}}},
+		{"PipelineDir", bson.D{{"bsonType", "string"}}},
+		{"SeparateInstanceResults", bson.D{{"bsonType", "bool"}}},
+		{"BaselineRunID", bson.D{{"bsonType", "string"}}},
+		{"RegressionThreshold", bson.D{{"bsonType", bson.A{"int", "long", "double", "decimal"}}, {"minimum", 0}}},
+		{"InstallConfigValidator", bson.D{{"bsonType", "bool"}}},
+		{"OperationTimeoutSecs", intSchema(0)},
+		{"ResumeLoad", bson.D{{"bsonType", "bool"}}},
+		{"Seed", bson.D{{"bsonType", integerTypes}, {"multipleOf", 1}}},
+		{"ParameterFile", bson.D{{"bsonType", "string"}}},
+		{"FamilySize", rangeSchema},
+		{"SharedDevices", rangeSchema},
+		{"PersonalDevices", rangeSchema},
+		{"InsertBatchSize", intSchema(0)},
+		{"SchemaVariant", bson.D{{"bsonType", "string"}}},
+		{"DeviceBucketSize", intSchema(0)},
+		{"Distributions", bson.D{{"bsonType", "object"}}},
+		{"DateRangeDays", intSchema(0)},
+		{"ExportDir", bson.D{{"bsonType", "string"}}},
+		{"ExportFormat", bson.D{{"enum", bson.A{"", "json", "bson", "csv", "discard"}}}},
+	}},
+}
+
+// integerTypes are the BSON types accepted for integer settings. mongosh and Compass store a plain number such
+// as 5 as a double, which decodes into an integer setting as long as it is a whole number.
+var integerTypes = bson.A{"int", "long", "double"}
+
+// intSchema validates an integer setting with the given minimum.
+func intSchema(minimum int) bson.D {
+	return bson.D{{"bsonType", integerTypes}, {"multipleOf", 1}, {"minimum", minimum}}
+}
+
+// rangeSchema validates a Range setting. Null leaves the default range in place.
+var rangeSchema = bson.D{
+	{"bsonType", bson.A{"object", "null"}},
+	{"properties", bson.D{
+		{"Min", intSchema(0)},
+		{"Max", intSchema(0)},
+	}},
+}
+
+// InstallConfigValidator adds a $jsonSchema validator to the config collection so that invalid settings are
+// rejected when the config document is edited.
+func InstallConfigValidator(ctx context.Context, mongoDB *mongo.Database, configColl string) error {
+
+	collModCommand := bson.D{
+		{"collMod", configColl},
+		{"validator", bson.D{{"$jsonSchema", configSchema}}},
+		{"validationLevel", "strict"},
+		{"validationAction", "error"},
+	}
+	if err := mongoDB.RunCommand(ctx, collModCommand).Err(); err != nil {
+		return fmt.Errorf("failed to install validator on %s: %w", configColl, err)
+	}
+	return nil
+}
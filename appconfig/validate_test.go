@@ -0,0 +1,48 @@
+package appconfig
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateRanges(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		cfg        AppConfig
+		wantFields []string //Settings reported as invalid
+	}{
+		{name: "defaults", cfg: AppConfig{}},
+		{name: "no devices", cfg: AppConfig{SharedDevices: &Range{}, PersonalDevices: &Range{}}},
+		{name: "empty families", cfg: AppConfig{FamilySize: &Range{}}, wantFields: []string{"FamilySize"}},
+		{name: "reversed", cfg: AppConfig{PersonalDevices: &Range{Min: 3, Max: 2}}, wantFields: []string{"PersonalDevices"}},
+		{name: "negative", cfg: AppConfig{SharedDevices: &Range{Min: -1, Max: 2}}, wantFields: []string{"SharedDevices"}},
+	}
+	for _, test := range tests {
+		cfg := test.cfg
+		cfg.DBName, cfg.Connections, cfg.GoRoutines, cfg.Profiles = "test", 1, 1, 10
+		var got []string
+		var problems ValidationError
+		if err := cfg.ValidateExport(); errors.As(err, &problems) {
+			for _, problem := range problems {
+				got = append(got, problem.Field)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: ValidateExport() = %v", test.name, err)
+		}
+		if len(got) != len(test.wantFields) || (len(got) > 0 && got[0] != test.wantFields[0]) {
+			t.Errorf("%s: ValidateExport() reported %q, want %q", test.name, got, test.wantFields)
+		}
+	}
+}
+
+func TestRangeDefaults(t *testing.T) {
+
+	cfg := AppConfig{SharedDevices: &Range{}}
+	if got := cfg.SharedDevicesRange(); got != (Range{}) {
+		t.Errorf("SharedDevicesRange() = %+v, want the configured 0 to 0", got)
+	}
+	if got := cfg.PersonalDevicesRange(); got != DefaultPersonalDevices {
+		t.Errorf("PersonalDevicesRange() = %+v, want the default %+v", got, DefaultPersonalDevices)
+	}
+}
@@ -0,0 +1,187 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"pipeline_blog/appconfig"
+)
+
+// histogramSubBucketBits controls the precision of the latency histogram. Each power of two range of
+// values is split into 2^(histogramSubBucketBits-1) equal width buckets, giving a relative error of under 3%.
+const histogramSubBucketBits = 6
+
+// LatencyStats summarises the latency of the individual iterations of a test. Times are in milliseconds.
+type LatencyStats struct {
+	Count     int               `bson:"Count"`
+	Min       float64           `bson:"Min"`
+	Max       float64           `bson:"Max"`
+	Mean      float64           `bson:"Mean"`
+	StdDev    float64           `bson:"StdDev"`
+	P50       float64           `bson:"P50"`
+	P90       float64           `bson:"P90"`
+	P95       float64           `bson:"P95"`
+	P99       float64           `bson:"P99"`
+	P999      float64           `bson:"P999"`
+	Histogram []HistogramBucket `bson:"Histogram"`
+}
+
+// HistogramBucket counts the iterations whose latency in microseconds was >= LowerMicros and < UpperMicros.
+type HistogramBucket struct {
+	LowerMicros int64 `bson:"LowerMicros"`
+	UpperMicros int64 `bson:"UpperMicros"`
+	Count       int   `bson:"Count"`
+}
+
+// ComputeLatencyStats calculates the latency distribution of a set of iteration durations.
+func ComputeLatencyStats(durations []time.Duration) LatencyStats {
+
+	var stats LatencyStats
+	stats.Count = len(durations)
+	if stats.Count == 0 {
+		return stats
+	}
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum float64
+	for _, d := range sorted {
+		sum += toMillis(d)
+	}
+	stats.Mean = sum / float64(stats.Count)
+	var sumSquares float64
+	for _, d := range sorted {
+		diff := toMillis(d) - stats.Mean
+		sumSquares += diff * diff
+	}
+	stats.StdDev = math.Sqrt(sumSquares / float64(stats.Count))
+	stats.Min = toMillis(sorted[0])
+	stats.Max = toMillis(sorted[len(sorted)-1])
+	stats.P50 = percentile(sorted, 500)
+	stats.P90 = percentile(sorted, 900)
+	stats.P95 = percentile(sorted, 950)
+	stats.P99 = percentile(sorted, 990)
+	stats.P999 = percentile(sorted, 999)
+	stats.Histogram = histogram(sorted)
+	return stats
+}
+
+// percentile uses the nearest-rank method on a sorted set of durations. The percentile is given in tenths of
+// a percent, so the rank is calculated exactly - in floating point, 99.9/100*1000 is just over 999.
+func percentile(sorted []time.Duration, perMille int) float64 {
+	rank := (perMille*len(sorted) + 999) / 1000
+	if rank < 1 {
+		rank = 1
+	}
+	return toMillis(sorted[rank-1])
+}
+
+// histogram buckets the durations HDR style - buckets are linear within each power of two and only
+// buckets containing at least one duration are returned.
+func histogram(sorted []time.Duration) []HistogramBucket {
+
+	var buckets []HistogramBucket
+	for _, d := range sorted {
+		lower, upper := histogramBucketBounds(d.Microseconds())
+		if n := len(buckets); n > 0 && buckets[n-1].LowerMicros == lower {
+			buckets[n-1].Count++
+			continue
+		}
+		buckets = append(buckets, HistogramBucket{LowerMicros: lower, UpperMicros: upper, Count: 1})
+	}
+	return buckets
+}
+
+func histogramBucketBounds(micros int64) (int64, int64) {
+	if micros < 0 {
+		micros = 0
+	}
+	shift := bits.Len64(uint64(micros)) - histogramSubBucketBits
+	if shift < 0 {
+		shift = 0
+	}
+	lower := (micros >> shift) << shift
+	return lower, lower + (1 << shift)
+}
+
+func toMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// SaveLatencyStats computes the latency distribution of the iterations recorded against a test and saves it to the results document.
+func SaveLatencyStats(ctx context.Context, mdb *mongo.Database, testName string) error {
+
+	ctx, cancel := OperationContext(ctx)
+	defer cancel()
+
+	var durations []time.Duration
+	var err error
+	if appconfig.ConfigData.SeparateInstanceResults {
+		durations, err = readInstanceDocDurations(ctx, mdb, testName)
+	} else {
+		durations, err = readInstanceArrayDurations(ctx, mdb, testName)
+	}
+	if err != nil {
+		return err
+	}
+	resultsColl := mdb.Collection(appconfig.ConfigData.ResultsColl)
+	filter := ResultFilter(testName)
+	updates := bson.D{
+		{"$set", bson.D{{"LatencyStats", ComputeLatencyStats(durations)}}},
+	}
+	_, err = resultsColl.UpdateOne(ctx, filter, updates)
+	if err != nil {
+		return fmt.Errorf("failed to save latency stats for %s: %w", testName, err)
+	}
+	return nil
+}
+
+func readInstanceArrayDurations(ctx context.Context, mdb *mongo.Database, testName string) ([]time.Duration, error) {
+
+	resultsColl := mdb.Collection(appconfig.ConfigData.ResultsColl)
+	filter := ResultFilter(testName)
+	opts := options.FindOne().SetProjection(bson.D{{"InstanceResults.DurationMicros", 1}})
+	var result TestResult
+	err := resultsColl.FindOne(ctx, filter, opts).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance results for %s: %w", testName, err)
+	}
+	durations := make([]time.Duration, len(result.InstanceResults))
+	for i, instance := range result.InstanceResults {
+		durations[i] = time.Duration(instance.DurationMicros) * time.Microsecond
+	}
+	return durations, nil
+}
+
+func readInstanceDocDurations(ctx context.Context, mdb *mongo.Database, testName string) ([]time.Duration, error) {
+
+	instancesColl := mdb.Collection(appconfig.ConfigData.InstanceResultsColl())
+	filter := ResultFilter(testName)
+	opts := options.Find().SetProjection(bson.D{{"DurationMicros", 1}})
+	cursor, err := instancesColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance results for %s: %w", testName, err)
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+	var durations []time.Duration
+	for cursor.Next(ctx) {
+		var instance InstanceResult
+		if err := cursor.Decode(&instance); err != nil {
+			return nil, fmt.Errorf("failed to decode instance result for %s: %w", testName, err)
+		}
+		durations = append(durations, time.Duration(instance.DurationMicros)*time.Microsecond)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read instance results for %s: %w", testName, err)
+	}
+	return durations, nil
+}
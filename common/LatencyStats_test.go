@@ -0,0 +1,168 @@
+package common
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// millis returns durations of the given numbers of milliseconds.
+func millis(values ...int) []time.Duration {
+	durations := make([]time.Duration, len(values))
+	for i, v := range values {
+		durations[i] = time.Duration(v) * time.Millisecond
+	}
+	return durations
+}
+
+// sequence returns durations of 1 to n milliseconds, in reverse order so that they have to be sorted.
+func sequence(n int) []time.Duration {
+	durations := make([]time.Duration, n)
+	for i := range durations {
+		durations[i] = time.Duration(n-i) * time.Millisecond
+	}
+	return durations
+}
+
+func TestComputeLatencyStats(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		want      LatencyStats //Excluding StdDev and the histogram
+		stdDev    float64
+	}{
+		{
+			name: "empty",
+			want: LatencyStats{},
+		},
+		{
+			name:      "single iteration",
+			durations: millis(7),
+			want:      LatencyStats{Count: 1, Min: 7, Max: 7, Mean: 7, P50: 7, P90: 7, P95: 7, P99: 7, P999: 7},
+		},
+		{
+			name:      "two iterations",
+			durations: millis(4, 2),
+			want:      LatencyStats{Count: 2, Min: 2, Max: 4, Mean: 3, P50: 2, P90: 4, P95: 4, P99: 4, P999: 4},
+			stdDev:    1,
+		},
+		{
+			//With fewer than 1000 iterations the 99.9th percentile is the slowest iteration
+			name:      "fewer than 1000 iterations",
+			durations: sequence(100),
+			want:      LatencyStats{Count: 100, Min: 1, Max: 100, Mean: 50.5, P50: 50, P90: 90, P95: 95, P99: 99, P999: 100},
+			stdDev:    math.Sqrt((100*100 - 1) / 12.0),
+		},
+		{
+			name:      "1000 iterations",
+			durations: sequence(1000),
+			want:      LatencyStats{Count: 1000, Min: 1, Max: 1000, Mean: 500.5, P50: 500, P90: 900, P95: 950, P99: 990, P999: 999},
+			stdDev:    math.Sqrt((1000*1000 - 1) / 12.0),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ComputeLatencyStats(test.durations)
+			if math.Abs(got.StdDev-test.stdDev) > 1e-9 {
+				t.Errorf("ComputeLatencyStats() StdDev = %g, want %g", got.StdDev, test.stdDev)
+			}
+			got.StdDev, got.Histogram = 0, nil
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ComputeLatencyStats() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestComputeLatencyStatsDoesNotSortInput(t *testing.T) {
+
+	durations := millis(3, 1, 2)
+	ComputeLatencyStats(durations)
+	if durations[0] != 3*time.Millisecond {
+		t.Errorf("ComputeLatencyStats() reordered its input: %v", durations)
+	}
+}
+
+func TestHistogramBucketBounds(t *testing.T) {
+
+	tests := []struct {
+		micros       int64
+		lower, upper int64
+	}{
+		{-5, 0, 1},
+		{0, 0, 1},
+		{1, 1, 2},
+		//Below 2^histogramSubBucketBits every value has a bucket of its own
+		{63, 63, 64},
+		//Each power of two from there on is split into 32 buckets, so they double in width
+		{64, 64, 66},
+		{65, 64, 66},
+		{127, 126, 128},
+		{128, 128, 132},
+		{131, 128, 132},
+		{1023, 1008, 1024},
+		{1024, 1024, 1056},
+		{1 << 20, 1 << 20, 1<<20 + 1<<15},
+		{1<<21 - 1, 1<<21 - 1<<15, 1 << 21},
+	}
+	for _, test := range tests {
+		lower, upper := histogramBucketBounds(test.micros)
+		if lower != test.lower || upper != test.upper {
+			t.Errorf("histogramBucketBounds(%d) = [%d, %d), want [%d, %d)", test.micros, lower, upper, test.lower, test.upper)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+
+	durations := []time.Duration{
+		63 * time.Microsecond,
+		64 * time.Microsecond,
+		65 * time.Microsecond,
+		66 * time.Microsecond,
+		128 * time.Microsecond,
+		131 * time.Microsecond,
+	}
+	want := []HistogramBucket{
+		{LowerMicros: 63, UpperMicros: 64, Count: 1},
+		{LowerMicros: 64, UpperMicros: 66, Count: 2},
+		{LowerMicros: 66, UpperMicros: 68, Count: 1},
+		{LowerMicros: 128, UpperMicros: 132, Count: 2},
+	}
+	got := ComputeLatencyStats(durations).Histogram
+	if len(got) != len(want) {
+		t.Fatalf("histogram = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("histogram bucket %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPercentileRank(t *testing.T) {
+
+	//Durations of 1 to n milliseconds, so the 99.9th percentile in milliseconds is its nearest rank
+	tests := []struct {
+		n    int
+		want float64
+	}{
+		{1, 1},
+		{999, 999},
+		{1000, 999},
+		{1001, 1000},
+		{41000, 40959},
+		{164000, 163836},
+	}
+	for _, test := range tests {
+		sorted := make([]time.Duration, test.n)
+		for i := range sorted {
+			sorted[i] = time.Duration(i+1) * time.Millisecond
+		}
+		if got := percentile(sorted, 999); got != test.want {
+			t.Errorf("percentile(1..%d, 999) = %g, want %g", test.n, got, test.want)
+		}
+	}
+}
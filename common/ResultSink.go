@@ -0,0 +1,178 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"pipeline_blog/appconfig"
+)
+
+// resultFlushBatchSize is the number of instance results written to MongoDB in each update when a sink is flushed.
+const resultFlushBatchSize = 1000
+
+// ResultSink collects the results of individual test iterations in memory on a background goroutine, so
+// that nothing is written to MongoDB until Close is called after the timed part of a test has completed.
+type ResultSink struct {
+	mdb      *mongo.Database
+	testName string
+	results  chan InstanceResult
+	buffer   []InstanceResult
+	done     chan struct{}
+}
+
+// NewResultSink starts a sink collecting results for the named test.
+func NewResultSink(mdb *mongo.Database, testName string) *ResultSink {
+
+	sink := &ResultSink{
+		mdb:      mdb,
+		testName: testName,
+		results:  make(chan InstanceResult, resultFlushBatchSize),
+		done:     make(chan struct{}),
+	}
+	go sink.collect()
+	return sink
+}
+
+func (s *ResultSink) collect() {
+	defer close(s.done)
+	for result := range s.results {
+		s.buffer = append(s.buffer, result)
+	}
+}
+
+// Record queues the result of a single test iteration. It is safe to call from multiple goroutines.
+func (s *ResultSink) Record(result InstanceResult) {
+	s.results <- result
+}
+
+// Close stops collecting results and writes everything recorded back to MongoDB in bulk - either to the
+// test's results document, or the instances collection if SeparateInstanceResults is set.
+// Record must not be called once Close has been called.
+func (s *ResultSink) Close(ctx context.Context) error {
+
+	close(s.results)
+	<-s.done
+
+	var err error
+	if appconfig.ConfigData.SeparateInstanceResults {
+		err = s.writeInstanceDocs(ctx)
+	} else {
+		err = s.writeInstanceArray(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	//Record the number of iterations actually executed
+	updates := bson.D{
+		{"$set", bson.D{{"InstanceCount", len(s.buffer)}}},
+	}
+	opCtx, cancel := OperationContext(ctx)
+	defer cancel()
+	_, err = s.mdb.Collection(appconfig.ConfigData.ResultsColl).UpdateOne(opCtx, ResultFilter(s.testName), updates)
+	if err != nil {
+		return fmt.Errorf("failed to save instance count for %s: %w", s.testName, err)
+	}
+	if appconfig.ConfigData.Debug {
+		log.Printf("Wrote %d instance results for %s", len(s.buffer), s.testName)
+	}
+	return nil
+}
+
+// writeInstanceArray appends the results to the InstanceResults array of the test's results document.
+func (s *ResultSink) writeInstanceArray(ctx context.Context) error {
+
+	resultsColl := s.mdb.Collection(appconfig.ConfigData.ResultsColl)
+	filter := ResultFilter(s.testName)
+	for start := 0; start < len(s.buffer); start += resultFlushBatchSize {
+		end := min(start+resultFlushBatchSize, len(s.buffer))
+		updates := bson.D{
+			{"$push", bson.D{
+				{"InstanceResults", bson.D{{"$each", s.buffer[start:end]}}},
+			}},
+		}
+		opCtx, cancel := OperationContext(ctx)
+		_, err := resultsColl.UpdateOne(opCtx, filter, updates)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to write instance results for %s: %w", s.testName, err)
+		}
+	}
+	updates := bson.A{
+		bson.D{
+			{"$set", bson.D{
+				{"InstanceAverage", bson.D{{"$divide", bson.A{bson.D{{"$avg", "$InstanceResults.DurationMicros"}}, 1000}}}},
+			}},
+		},
+	}
+	opCtx, cancel := OperationContext(ctx)
+	defer cancel()
+	_, err := resultsColl.UpdateOne(opCtx, filter, updates)
+	if err != nil {
+		return fmt.Errorf("failed to save instance average for %s: %w", s.testName, err)
+	}
+	return nil
+}
+
+// writeInstanceDocs inserts each result as its own document in the instances collection, keeping the
+// results document well clear of the 16MB document size limit, then summarises them on the results document.
+func (s *ResultSink) writeInstanceDocs(ctx context.Context) error {
+
+	instancesColl := s.mdb.Collection(appconfig.ConfigData.InstanceResultsColl())
+	var insertOpts options.InsertManyOptions
+	for start := 0; start < len(s.buffer); start += resultFlushBatchSize {
+		end := min(start+resultFlushBatchSize, len(s.buffer))
+		var docs []interface{}
+		for _, result := range s.buffer[start:end] {
+			result.RunID = RunID
+			result.TestName = s.testName
+			docs = append(docs, result)
+		}
+		opCtx, cancel := OperationContext(ctx)
+		_, err := instancesColl.InsertMany(opCtx, docs, insertOpts.SetOrdered(false))
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to write instance results for %s: %w", s.testName, err)
+		}
+	}
+
+	//Summarise the iteration results
+	pipeline := mongo.Pipeline{
+		bson.D{{"$match", ResultFilter(s.testName)}},
+		bson.D{{"$group", bson.D{
+			{"_id", nil},
+			{"InstanceAverage", bson.D{{"$avg", "$DurationMicros"}}},
+		}}},
+		bson.D{{"$set", bson.D{
+			{"InstanceAverage", bson.D{{"$divide", bson.A{"$InstanceAverage", 1000}}}},
+		}}},
+	}
+	opCtx, cancel := OperationContext(ctx)
+	defer cancel()
+	cursor, err := instancesColl.Aggregate(opCtx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to summarise instance results for %s: %w", s.testName, err)
+	}
+	var summaries []TestResult
+	err = cursor.All(opCtx, &summaries)
+	if err != nil {
+		return fmt.Errorf("failed to decode instance result summary for %s: %w", s.testName, err)
+	}
+	if len(summaries) == 0 {
+		return nil
+	}
+	resultsColl := s.mdb.Collection(appconfig.ConfigData.ResultsColl)
+	filter := ResultFilter(s.testName)
+	updates := bson.D{
+		{"$set", bson.D{{"InstanceAverage", summaries[0].InstanceAverage}}},
+	}
+	_, err = resultsColl.UpdateOne(opCtx, filter, updates)
+	if err != nil {
+		return fmt.Errorf("failed to save instance average for %s: %w", s.testName, err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"pipeline_blog/appconfig"
+)
+
+// RunID identifies this invocation of the program. It is stamped onto every results document it writes.
+var RunID = primitive.NewObjectID()
+
+// CurrentRun describes this invocation of the program. It is populated by StartRun.
+var CurrentRun RunInfo
+
+// RunInfo records the circumstances of a program run so results can be compared across runs.
+type RunInfo struct {
+	StartTime time.Time           `bson:"StartTime"`
+	Build     BuildInfo           `bson:"Build"`
+	Host      HostInfo            `bson:"Host"`
+	Config    appconfig.AppConfig `bson:"Config"`
+}
+
+// BuildInfo is read from the information the Go toolchain embeds in the executable.
+type BuildInfo struct {
+	GoVersion string `bson:"GoVersion"`
+	Module    string `bson:"Module"`
+	Version   string `bson:"Version"`
+	Sum       string `bson:"Sum"`
+}
+
+// HostInfo describes the machine the program ran on.
+type HostInfo struct {
+	Hostname string `bson:"Hostname"`
+	OS       string `bson:"OS"`
+	Arch     string `bson:"Arch"`
+	NumCPU   int    `bson:"NumCPU"`
+	PID      int    `bson:"PID"`
+}
+
+// StartRun records the details of this run. It should be called once the config data has been read.
+func StartRun() {
+
+	CurrentRun.StartTime = time.Now()
+	CurrentRun.Config = appconfig.ConfigData
+
+	CurrentRun.Build.GoVersion = runtime.Version()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		CurrentRun.Build.Module = info.Main.Path
+		CurrentRun.Build.Version = info.Main.Version
+		CurrentRun.Build.Sum = info.Main.Sum
+	}
+
+	CurrentRun.Host.Hostname, _ = os.Hostname()
+	CurrentRun.Host.OS = runtime.GOOS
+	CurrentRun.Host.Arch = runtime.GOARCH
+	CurrentRun.Host.NumCPU = runtime.NumCPU()
+	CurrentRun.Host.PID = os.Getpid()
+}
+
+// ResultFilter matches the results document for the named test in this run.
+func ResultFilter(testName string) bson.D {
+	return bson.D{{"RunID", RunID}, {"TestName", testName}}
+}
@@ -2,7 +2,7 @@ package common
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"pipeline_blog/appconfig"
@@ -14,60 +14,124 @@ import (
 	"sync"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var MasterWG sync.WaitGroup
 
+// WorkerErrors collects the errors returned by a group of goroutines. It is safe to use from multiple goroutines.
+type WorkerErrors struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// Add records an error. Nil errors are ignored.
+func (w *WorkerErrors) Add(err error) {
+	if err == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errs = append(w.errs, err)
+}
+
+// Err returns every error recorded, joined together, or nil if there were none.
+func (w *WorkerErrors) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return errors.Join(w.errs...)
+}
+
+// OperationContext bounds a single database operation by the configured operation timeout.
+func OperationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, appconfig.ConfigData.OperationTimeout())
+}
+
+// CleanupContext returns a context for work that must still be done once ctx has been cancelled, such as hiding
+// indexes or saving partial results after an interrupt. It is bounded by the operation timeout.
+func CleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return OperationContext(context.WithoutCancel(ctx))
+}
+
+// Share returns the number of items out of total allocated to worker i of the given number of workers.
+// The remainder of an uneven split is handed out one item at a time to the first workers, so every item is allocated.
+func Share(total, workers, i int) int {
+	share := total / workers
+	if i < total%workers {
+		share++
+	}
+	return share
+}
+
 type TestResult struct {
-	TestName        string           `bson:"TestName"`
-	StartTime       time.Time        `bson:"StartTime"`
-	EndTime         time.Time        `bson:"EndTime"`
-	Duration        int              `bson:"Duration"`
-	InstanceResults []InstanceResult `bson:"InstanceResults"`
-	InstanceAverage int              `bson:"InstanceAverage"`
+	RunID             primitive.ObjectID `bson:"RunID,omitempty"`
+	Run               *RunInfo           `bson:"Run,omitempty"`
+	TestName          string             `bson:"TestName"`
+	StartTime         time.Time          `bson:"StartTime"`
+	EndTime           time.Time          `bson:"EndTime"`
+	Duration          int                `bson:"Duration"` //Milliseconds, kept for compatibility with earlier results
+	DurationMicros    int64              `bson:"DurationMicros"`
+	InstanceResults   []InstanceResult   `bson:"InstanceResults"`
+	InstanceAverage   float64            `bson:"InstanceAverage"`             //Milliseconds, calculated from DurationMicros
+	InstanceCount     int                `bson:"InstanceCount,omitempty"`     //Number of pipeline iterations executed
+	Aborted           bool               `bson:"Aborted,omitempty"`           //The test was interrupted, so only some iterations were executed
+	Resumed           bool               `bson:"Resumed,omitempty"`           //The data load continued an earlier, unfinished load
+	ProfileCount      int                `bson:"ProfileCount,omitempty"`      //Number of profiles generated by a data load
+	DeviceCount       int                `bson:"DeviceCount,omitempty"`       //Number of devices generated by a data load
+	MappingCount      int                `bson:"MappingCount,omitempty"`      //Number of mappings generated by a data load
+	DeviceBucketCount int                `bson:"DeviceBucketCount,omitempty"` //Number of device buckets generated by a data load
+	LatencyStats      *LatencyStats      `bson:"LatencyStats,omitempty"`
 }
 
 type InstanceResult struct {
-	StartTime     time.Time `bson:"StartTime"`
-	EndTime       time.Time `bson:"EndTime"`
-	Duration      int       `bson:"Duration"`
-	ConnectionNum int       `bson:"ConnectionNum"`
-	RoutineNum    int       `bson:"RoutineNum"`
-	City          string    `bson:"City"`
-	DeviceName    string    `bson:"DeviceName"`
+	RunID          primitive.ObjectID `bson:"RunID,omitempty"`    //Only set when written to the instances collection
+	TestName       string             `bson:"TestName,omitempty"` //Only set when written to the instances collection
+	StartTime      time.Time          `bson:"StartTime"`
+	EndTime        time.Time          `bson:"EndTime"`
+	Duration       int                `bson:"Duration"` //Milliseconds, kept for compatibility with earlier results
+	DurationMicros int64              `bson:"DurationMicros"`
+	ConnectionNum  int                `bson:"ConnectionNum"`
+	RoutineNum     int                `bson:"RoutineNum"`
+	City           string             `bson:"City"`
+	DeviceName     string             `bson:"DeviceName"`
 }
 
-func CreateIndex(coll *mongo.Collection, indexModel mongo.IndexModel, wg *sync.WaitGroup) {
+// CreateIndex builds an index. Index builds can take a long time on a large collection, so they are only
+// bounded by ctx rather than the operation timeout.
+func CreateIndex(ctx context.Context, coll *mongo.Collection, indexModel mongo.IndexModel) error {
 
-	defer wg.Done()
-	name, err := coll.Indexes().CreateOne(context.TODO(), indexModel)
+	name, err := coll.Indexes().CreateOne(ctx, indexModel)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to create index on %s: %w", coll.Name(), err)
 	}
 	log.Print("Name of Index Created: " + name)
-
+	return nil
 }
 
-func GetMongoDatabase(mongoDBURI, mongoDBName string) *mongo.Database {
+func GetMongoDatabase(ctx context.Context, mongoDBURI, mongoDBName string) (*mongo.Database, error) {
 
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(mongoDBURI).SetTLSConfig(&tls.Config{}).SetAppName("InsuranceLoader"))
+	ctx, cancel := OperationContext(ctx)
+	defer cancel()
+	//TLS is enabled by the URI - mongodb+srv URIs enable it by default
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoDBURI).SetAppName("InsuranceLoader"))
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
 	// Send a ping to confirm a successful connection
-	if err := client.Ping(context.TODO(), nil); err != nil {
-		log.Fatal(err)
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.WithoutCancel(ctx))
+		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
 	}
 
-	return client.Database(mongoDBName)
+	return client.Database(mongoDBName), nil
 
 }
 
-func SeedCollection(mdb *mongo.Database, wg *sync.WaitGroup, collName string) {
+// SeedCollection reads a whole collection to pull it into the cache. Like CreateIndex, it is only bounded by ctx.
+func SeedCollection(ctx context.Context, mdb *mongo.Database, collName string) error {
 
-	defer wg.Done()
 	filter := bson.D{}
 	if collName == "Profiles" {
 		pattern := `^[A-Za-z]`
@@ -79,30 +143,37 @@ func SeedCollection(mdb *mongo.Database, wg *sync.WaitGroup, collName string) {
 		filter = bson.D{
 			{"deviceSN", bson.D{{"$regex", pattern}}},
 		}
-	} else if collName == "Mappings" {
+	} else if collName == "Mappings" || collName == "DeviceBuckets" {
 		pattern := `^[A-Za-z]`
 		filter = bson.D{
 			{"profileID", bson.D{{"$regex", pattern}}},
 		}
 	}
-	cursor, err := mdb.Collection(collName).Find(context.TODO(), filter)
+	cursor, err := mdb.Collection(collName).Find(ctx, filter)
 	if err != nil {
-		log.Fatalf("Failed to seed cach (%s): %v", collName, err)
+		return fmt.Errorf("failed to seed cache (%s): %w", collName, err)
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(context.WithoutCancel(ctx))
 	c := 0
-	for cursor.Next(context.TODO()) {
+	for cursor.Next(ctx) {
 		var seedDoc interface{}
 		err = cursor.Decode(&seedDoc)
 		if err != nil {
-			log.Fatalf("Failed to decode seeding doc: %v", err)
+			return fmt.Errorf("failed to decode seeding doc (%s): %w", collName, err)
 		}
 		c++
 	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to seed cache (%s): %w", collName, err)
+	}
 	log.Printf("Decoded %d docs seeding %s collection", c, collName)
+	return nil
 }
 
-func HideIndex(indexName, collectionName string, db *mongo.Database, hide bool) {
+func HideIndex(ctx context.Context, indexName, collectionName string, db *mongo.Database, hide bool) error {
+
+	ctx, cancel := OperationContext(ctx)
+	defer cancel()
 
 	// Hide the index
 	collModCommand := bson.D{
@@ -112,43 +183,71 @@ func HideIndex(indexName, collectionName string, db *mongo.Database, hide bool)
 			{"hidden", hide},
 		}},
 	}
-	res := db.RunCommand(context.TODO(), collModCommand)
+	res := db.RunCommand(ctx, collModCommand)
 	if res.Err() != nil {
-		log.Fatal(res.Err())
+		return fmt.Errorf("failed to set hidden=%t on index %s: %w", hide, indexName, res.Err())
 	}
+	return nil
 }
 
-func CreateResultDoc(mdb *mongo.Database, testName string) {
+func CreateResultDoc(ctx context.Context, mdb *mongo.Database, testName string) error {
+
+	ctx, cancel := OperationContext(ctx)
+	defer cancel()
 
 	result := TestResult{
+		RunID:           RunID,
+		Run:             &CurrentRun,
 		TestName:        testName,
 		InstanceResults: []InstanceResult{},
 	}
 	resultsColl := mdb.Collection(appconfig.ConfigData.ResultsColl)
-	_, err := resultsColl.InsertOne(context.TODO(), result)
+	_, err := resultsColl.InsertOne(ctx, result)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to create results document for %s: %w", testName, err)
 	}
+	return nil
 }
 
-func SaveDuration(startTime, endTime time.Time, mdb *mongo.Database, testName string) {
+func SaveDuration(ctx context.Context, startTime, endTime time.Time, mdb *mongo.Database, testName string) error {
+
+	ctx, cancel := OperationContext(ctx)
+	defer cancel()
 
 	resultsColl := mdb.Collection(appconfig.ConfigData.ResultsColl)
 
 	//Save the execution duration back to MongoDB
-	duration := int(endTime.UnixMilli() - startTime.UnixMilli())
-	filter := bson.D{{"TestName", testName}}
+	duration := endTime.Sub(startTime)
+	filter := ResultFilter(testName)
 	updates := bson.D{
 		{"$set", bson.D{
 			{"StartTime", startTime},
 			{"EndTime", endTime},
-			{"Duration", duration},
+			{"Duration", int(duration.Milliseconds())},
+			{"DurationMicros", duration.Microseconds()},
 		}},
 	}
-	_, err := resultsColl.UpdateOne(context.TODO(), filter, updates)
+	_, err := resultsColl.UpdateOne(ctx, filter, updates)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to save duration for %s: %w", testName, err)
 	}
+	return nil
+}
+
+// MarkAborted flags the results document for a test that was interrupted before all its iterations were executed.
+func MarkAborted(ctx context.Context, mdb *mongo.Database, testName string) error {
+
+	ctx, cancel := OperationContext(ctx)
+	defer cancel()
+	resultsColl := mdb.Collection(appconfig.ConfigData.ResultsColl)
+	updates := bson.D{
+		{"$set", bson.D{{"Aborted", true}}},
+	}
+	_, err := resultsColl.UpdateOne(ctx, ResultFilter(testName), updates)
+	if err != nil {
+		return fmt.Errorf("failed to mark %s as aborted: %w", testName, err)
+	}
+	return nil
 }
 
 func resolveSRV(uri string) ([]string, error) {
@@ -178,14 +277,59 @@ func resolveSRV(uri string) ([]string, error) {
 	return hosts, nil
 }
 
-func GenerateDirectConnectionStrings(srvURI string) ([]string, error) {
-	hosts, err := resolveSRV(srvURI)
+// parseHosts extracts the hosts from a standard (non-SRV) connection string, along with the database
+// and any options that should be carried over to direct connections to those hosts.
+func parseHosts(uri string) ([]string, string, string, error) {
+	uriParts := strings.Split(uri, "://")
+	if len(uriParts) != 2 {
+		return nil, "", "", fmt.Errorf("invalid URI")
+	}
+	host, path, _ := strings.Cut(uriParts[1], "/")
+	//Keep credentials with each host
+	credentials := ""
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		credentials, host = host[:i+1], host[i+1:]
+	}
+	hosts := strings.Split(host, ",")
+	for i := range hosts {
+		hosts[i] = credentials + hosts[i]
+	}
+	//Direct connections can't use the replica set options
+	var opts []string
+	database, query, _ := strings.Cut(path, "?")
+	if query != "" {
+		for _, opt := range strings.Split(query, "&") {
+			name, _, _ := strings.Cut(opt, "=")
+			if opt != "" && !strings.EqualFold(name, "replicaSet") && !strings.EqualFold(name, "directConnection") {
+				opts = append(opts, opt)
+			}
+		}
+	}
+	return hosts, database, strings.Join(opts, "&"), nil
+}
+
+// GenerateDirectConnectionStrings returns a connection string for a direct connection to each host in the
+// cluster. The hosts are looked up in DNS for mongodb+srv URIs, otherwise they are read from the URI itself.
+func GenerateDirectConnectionStrings(uri string) ([]string, error) {
+	var hosts []string
+	var database, opts string
+	var err error
+	if strings.HasPrefix(uri, "mongodb+srv://") {
+		hosts, err = resolveSRV(uri)
+		//mongodb+srv URIs imply TLS, so the direct connections need it too
+		opts = "tls=true"
+	} else {
+		hosts, database, opts, err = parseHosts(uri)
+	}
 	if err != nil {
 		return nil, err
 	}
 	directConnectionStrings := make([]string, len(hosts))
 	for i, host := range hosts {
-		directConnectionStrings[i] = fmt.Sprintf("mongodb://%s/?directConnection=true", host)
+		directConnectionStrings[i] = fmt.Sprintf("mongodb://%s/%s?directConnection=true", host, database)
+		if opts != "" {
+			directConnectionStrings[i] += "&" + opts
+		}
 	}
 	return directConnectionStrings, nil
 }
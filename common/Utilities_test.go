@@ -0,0 +1,31 @@
+package common
+
+import "testing"
+
+func TestShare(t *testing.T) {
+
+	tests := []struct {
+		total, workers int
+		want           []int
+	}{
+		{0, 3, []int{0, 0, 0}},
+		{2, 3, []int{1, 1, 0}},
+		{9, 3, []int{3, 3, 3}},
+		{10, 3, []int{4, 3, 3}},
+		{11, 3, []int{4, 4, 3}},
+		{7, 1, []int{7}},
+	}
+	for _, test := range tests {
+		sum := 0
+		for i, want := range test.want {
+			got := Share(test.total, test.workers, i)
+			if got != want {
+				t.Errorf("Share(%d, %d, %d) = %d, want %d", test.total, test.workers, i, got, want)
+			}
+			sum += got
+		}
+		if sum != test.total {
+			t.Errorf("Share(%d, %d, i) allocated %d items in total", test.total, test.workers, sum)
+		}
+	}
+}
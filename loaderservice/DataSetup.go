@@ -2,14 +2,14 @@ package loaderservice
 
 import (
 	"context"
-	"encoding/json"
-	"math/rand"
-	"os"
+	"fmt"
+	"slices"
 	"strconv"
 
 	"log"
 
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"pipeline_blog/appconfig"
@@ -19,39 +19,51 @@ import (
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
-func LoadData() {
+// Counts of the documents written by the current data load, export or import
+var profilesGenerated, devicesGenerated, mappingsGenerated, bucketsGenerated atomic.Int64
 
-	log.Print("Data Load Started")
+func resetCounts() {
+	profilesGenerated.Store(0)
+	devicesGenerated.Store(0)
+	mappingsGenerated.Store(0)
+	bucketsGenerated.Store(0)
+}
 
-	mongoDBURI := os.Getenv("MONGODB_URI")
-	mongoDBName := os.Getenv("MONGODB_DB_NAME")
+// countsSummary describes the number of documents written, for logging.
+func countsSummary() string {
+	summary := fmt.Sprintf("%d profiles, %d devices, %d mappings", profilesGenerated.Load(), devicesGenerated.Load(), mappingsGenerated.Load())
+	if buckets := bucketsGenerated.Load(); buckets > 0 {
+		summary += fmt.Sprintf(", %d device buckets", buckets)
+	}
+	return summary
+}
+
+// LoadData generates the collections for the configured schema variant and creates their indexes. If ctx is
+// cancelled the load stops, and the documents generated so far are recorded in a result marked as aborted.
+// Each worker's progress is checkpointed, so if ResumeLoad is set an earlier, unfinished load is continued
+// rather than the collections being dropped and generated again.
+func LoadData(ctx context.Context) error {
+
+	resume := appconfig.ConfigData.ResumeLoad
+	if resume {
+		log.Print("Data Load Resumed")
+	} else {
+		log.Print("Data Load Started")
+	}
 
 	connectionCount := appconfig.ConfigData.Connections
 	routineCount := appconfig.ConfigData.GoRoutines
 	profiles := appconfig.ConfigData.Profiles
-
-	//Work out the number of profiles to be loaded by each connection.
-	profilesCount := profiles / connectionCount
+	resetCounts()
 
 	//Create the necessary number of Mongo Client / Database connections
-	var connections []*mongo.Database
-	for i := 0; i < connectionCount; i++ {
-		db := common.GetMongoDatabase(mongoDBURI, mongoDBName)
-		connections = append(connections, db)
+	connections, err := openConnections(ctx, connectionCount)
+	defer closeConnections(ctx, connections)
+	if err != nil {
+		return err
 	}
-	defer func() {
-		for i := 0; i < connectionCount; i++ {
-			if err := connections[i].Client().Disconnect(context.TODO()); err != nil {
-				log.Fatal(err)
-			}
-		}
-	}()
-
-	//Initialize the master wait group
-	common.MasterWG.Add(connectionCount)
 
 	//Create an array of sub-wait groups - one for each MDB connection
 	var wgs []*sync.WaitGroup
@@ -60,194 +72,293 @@ func LoadData() {
 		wgs = append(wgs, &wg)
 	}
 
-	//Drop the existing collections
-	coll := connections[0].Collection("Profiles")
-	coll.Drop(context.TODO())
-	coll = connections[0].Collection("Devices")
-	coll.Drop(context.TODO())
-	coll = connections[0].Collection("Mappings")
-	coll.Drop(context.TODO())
+	//Split the profiles between the workers, and pick up where an earlier load left off if we're resuming
+	plan := planLoad(profiles, connectionCount, routineCount)
+	if resume {
+		if err := resumeCheckpoints(ctx, connections[0], plan); err != nil {
+			return err
+		}
+	} else {
+		dropCollections(ctx, connections[0])
+		if err := resetCheckpoints(ctx, connections[0], plan); err != nil {
+			return err
+		}
+	}
+
+	//Initialize the master wait group
+	common.MasterWG.Add(connectionCount)
 
 	//Start a new Go Routine for each MDB connection
+	var loadErrs common.WorkerErrors
 	startTime := time.Now()
-	startID := 1
 	for i := 0; i < connectionCount; i++ {
-		go runDataLoads(connections[i], wgs[i], routineCount, startID, profilesCount)
-		startID += profilesCount
+		go runDataLoads(ctx, connections[i], wgs[i], plan[i], &loadErrs)
 	}
 	common.MasterWG.Wait()
 	endTime := time.Now()
+	loadErr := loadErrs.Err()
+	aborted := loadErr != nil || ctx.Err() != nil
 
 	//Save the execution duration back to MongoDB
+	result := loadResult("Pipeline Blog Data Load", startTime, endTime, aborted)
+	result.Resumed = resume
+	if err := saveLoadResult(ctx, connections[0], result); err != nil {
+		return err
+	}
+	if loadErr != nil {
+		return fmt.Errorf("data load failed after %d profiles - set ResumeLoad to continue it: %w", result.ProfileCount, loadErr)
+	}
+	if aborted {
+		return fmt.Errorf("data load interrupted after %d profiles - set ResumeLoad to continue it: %w", result.ProfileCount, ctx.Err())
+	}
+	log.Printf("Data Load Completed (%s) - creating Indexes", countsSummary())
+
+	return createIndexes(ctx, connections[0])
+}
+
+// openConnections creates the given number of Mongo Client / Database connections. The connections opened
+// before any failure are returned along with the error, so they can still be closed.
+func openConnections(ctx context.Context, connectionCount int) ([]*mongo.Database, error) {
+
+	var connections []*mongo.Database
+	for i := 0; i < connectionCount; i++ {
+		db, err := common.GetMongoDatabase(ctx, appconfig.ConfigData.MongoDBURI, appconfig.ConfigData.DBName)
+		if err != nil {
+			return connections, err
+		}
+		connections = append(connections, db)
+	}
+	return connections, nil
+}
+
+func closeConnections(ctx context.Context, connections []*mongo.Database) {
+
+	cleanupCtx, cancel := common.CleanupContext(ctx)
+	defer cancel()
+	for _, conn := range connections {
+		if err := conn.Client().Disconnect(cleanupCtx); err != nil {
+			log.Printf("Failed to disconnect from MongoDB: %v", err)
+		}
+	}
+}
+
+// dropCollections drops every data collection ahead of a new load, including any written by other schema variants.
+func dropCollections(ctx context.Context, mdb *mongo.Database) {
+
+	ctx, cancel := common.OperationContext(ctx)
+	defer cancel()
+	for _, collName := range dataColls {
+		mdb.Collection(collName).Drop(ctx)
+	}
+}
+
+// loadResult returns the result of a data load, holding the counts of the documents written.
+func loadResult(testName string, startTime, endTime time.Time, aborted bool) common.TestResult {
+
 	var result common.TestResult
-	result.TestName = "Pipeline Blog Data Load"
+	result.RunID = common.RunID
+	result.Run = &common.CurrentRun
+	result.TestName = testName
 	result.StartTime = startTime
 	result.EndTime = endTime
-	result.Duration = int(endTime.UnixMilli() - startTime.UnixMilli())
-	resultsColl := connections[0].Collection(appconfig.ConfigData.ResultsColl)
-	_, err := resultsColl.InsertOne(context.TODO(), result)
-	if err != nil {
-		log.Fatal(err)
+	result.Duration = int(endTime.Sub(startTime).Milliseconds())
+	result.DurationMicros = endTime.Sub(startTime).Microseconds()
+	result.ProfileCount = int(profilesGenerated.Load())
+	result.DeviceCount = int(devicesGenerated.Load())
+	result.MappingCount = int(mappingsGenerated.Load())
+	result.DeviceBucketCount = int(bucketsGenerated.Load())
+	result.Aborted = aborted
+	return result
+}
+
+// saveLoadResult records the result of a data load, even if the load was interrupted.
+func saveLoadResult(ctx context.Context, mdb *mongo.Database, result common.TestResult) error {
+
+	ctx, cancel := common.CleanupContext(ctx)
+	defer cancel()
+	if _, err := mdb.Collection(appconfig.ConfigData.ResultsColl).InsertOne(ctx, result); err != nil {
+		return fmt.Errorf("failed to save data load result: %w", err)
 	}
-	log.Print("Data Load Completed - creating Indexes")
+	return nil
+}
 
-	common.MasterWG.Add(1)
-	indexModel := mongo.IndexModel{
+// collIndex is an index on one of the data collections.
+type collIndex struct {
+	collName   string
+	indexModel mongo.IndexModel
+}
+
+// loadIndexes are the indexes used by the pipeline tests. The Profiles indexes are created hidden - the
+// performance tests unhide each one only while the pipelines that use it are running.
+var loadIndexes = []collIndex{
+	{"Profiles", mongo.IndexModel{
 		Keys: bson.D{
 			{"contact.address.city", 1},
 		},
 		Options: options.Index().SetHidden(true),
-	}
-	go common.CreateIndex(connections[0].Collection("Profiles"), indexModel, &common.MasterWG)
-
-	common.MasterWG.Add(1)
-	indexModel = mongo.IndexModel{
+	}},
+	{"Profiles", mongo.IndexModel{
 		Keys: bson.D{
 			{"contact.address.city", 1},
 			{"devices.deviceName", 1},
 		},
 		Options: options.Index().SetHidden(true),
-	}
-	go common.CreateIndex(connections[0].Collection("Profiles"), indexModel, &common.MasterWG)
-
-	common.MasterWG.Add(1)
-	indexModel = mongo.IndexModel{
+	}},
+	{"Profiles", mongo.IndexModel{
 		Keys: bson.D{
 			{"contact.address.city", 1},
 			{"devices.deviceName", 1},
 			{"profileID", 1},
 		},
 		Options: options.Index().SetHidden(true),
-	}
-	go common.CreateIndex(connections[0].Collection("Profiles"), indexModel, &common.MasterWG)
-
-	common.MasterWG.Add(1)
-	indexModel = mongo.IndexModel{
+	}},
+	{"Mappings", mongo.IndexModel{
 		Keys: bson.D{
 			{"profileID", 1},
 		},
-	}
-	go common.CreateIndex(connections[0].Collection("Mappings"), indexModel, &common.MasterWG)
-
-	common.MasterWG.Add(1)
-	indexModel = mongo.IndexModel{
+	}},
+	{"Devices", mongo.IndexModel{
 		Keys: bson.D{
 			{"deviceSN", 1},
 			{"deviceName", 1},
 		},
+	}},
+	{"DeviceBuckets", mongo.IndexModel{
+		Keys: bson.D{
+			{"profileID", 1},
+		},
+	}},
+}
+
+// schemaIndexes returns the indexes on the collections written for the configured schema variant.
+func schemaIndexes() []collIndex {
+	var indexes []collIndex
+	for _, index := range loadIndexes {
+		if slices.Contains(Collections(), index.collName) {
+			indexes = append(indexes, index)
+		}
 	}
-	go common.CreateIndex(connections[0].Collection("Devices"), indexModel, &common.MasterWG)
+	return indexes
+}
 
+// createIndexes builds the indexes used by the pipeline tests.
+func createIndexes(ctx context.Context, mdb *mongo.Database) error {
+
+	var indexErrs common.WorkerErrors
+	indexes := schemaIndexes()
+	common.MasterWG.Add(len(indexes))
+	for _, index := range indexes {
+		go func(collName string, indexModel mongo.IndexModel) {
+			defer common.MasterWG.Done()
+			indexErrs.Add(common.CreateIndex(ctx, mdb.Collection(collName), indexModel))
+		}(index.collName, index.indexModel)
+	}
 	common.MasterWG.Wait()
-
+	return indexErrs.Err()
 }
 
-func insertData(mdb *mongo.Database, wg *sync.WaitGroup, startProfile, endProfile int) {
+// insertData generates the profiles in a worker's range that have not yet been written and passes them to the sink.
+// The documents are written in batches, after each of which the worker's checkpoint is advanced past the profiles written.
+func insertData(ctx context.Context, sink documentSink, wg *sync.WaitGroup, checkpoint loadCheckpoint, errs *common.WorkerErrors) {
 
 	defer wg.Done()
+	if checkpoint.CompletedThrough >= checkpoint.End {
+		return
+	}
+	//Each range of profiles has its own stream of random values, so a seeded load is reproduced exactly
+	g := checkpoint.generator()
+	startProfile, endProfile := checkpoint.CompletedThrough, checkpoint.End
+	if g.seeded {
+		//Regenerate the profiles already written by a resumed load, so the rest follow on exactly as before
+		startProfile = checkpoint.Start
+	}
 
-	//Use Write Concern 1 for faster performance. Not recommended for a production system
-	wc := writeconcern.W1()
-	var collOpts options.CollectionOptions
-	collOpts.WriteConcern = wc
-	profileColl := mdb.Collection("Profiles", &collOpts)
-	deviceColl := mdb.Collection("Devices", &collOpts)
-	mappingsColl := mdb.Collection("Mappings", &collOpts)
-
-	var insertOrderedOp options.InsertManyOptions
-
-	var profileDocs []interface{}
-	var deviceDocs []interface{}
-	var mappingDocs []interface{}
+	batchSize := appconfig.ConfigData.InsertBatch()
+	layout := currentLayout()
+	//The documents generated for each collection since the last write, in the order the collections are written
+	devicePending := &pendingDocs{collName: "Devices", count: &devicesGenerated}
+	profilePending := &pendingDocs{collName: "Profiles", count: &profilesGenerated}
+	mappingPending := &pendingDocs{collName: "Mappings", count: &mappingsGenerated}
+	bucketPending := &pendingDocs{collName: "DeviceBuckets", count: &bucketsGenerated}
+	pending := []*pendingDocs{devicePending, profilePending, mappingPending, bucketPending}
 
 	for x := startProfile; x < endProfile; {
 
+		//Stop generating documents once the load has been interrupted
+		if ctx.Err() != nil {
+			return
+		}
+
 		var deviceSNs []string
 		var deviceNames []string
+		var devices []Device
 
-		famSize := rand.Intn(2) + 1
-		lastname := RandomLastName()
-		accountNum := randomAccountIDBase()
-		deviceNum := rand.Intn(5) + 1
+		famSize, finalSize := g.familySize()
+		lastname := g.RandomLastName()
+		accountNum := g.randomAccountIDBase()
+		deviceNum := g.sharedDeviceCount()
 
 		//Create the family's shared devices.
 		for i := 0; i < deviceNum; i++ {
-			deviceJSON, deviceSN, deviceName := GenerateDevice(true)
-			deviceSNs = append(deviceSNs, deviceSN)
-			deviceNames = append(deviceNames, deviceName)
-			var deviceBSON bson.D
-			err := bson.UnmarshalExtJSON([]byte(deviceJSON), true, &deviceBSON)
-			if err != nil {
-				log.Fatalf("Failed to convert Device JSON to BSON: %v", err)
-			}
-			deviceDocs = append(deviceDocs, deviceBSON)
+			device := g.GenerateDevice(true)
+			device.ID = g.objectID()
+			deviceSNs = append(deviceSNs, device.DeviceSN)
+			deviceNames = append(deviceNames, device.DeviceName)
+			devices = append(devices, device)
+			devicePending.add(layout.devices, device)
 		}
 
 		//Create Profiles
 		var primaryage int
-		address := randomAddress()
+		address := g.randomAddress()
 		for i := 1; i <= famSize; i++ {
 			var profile Profile
-			profileDeviceSNs := deviceSNs
-			profileDeviceNames := deviceNames
+			//Clipped, so that appending personal devices never overwrites those of an earlier profile
+			profileDeviceSNs := slices.Clip(deviceSNs)
+			profileDeviceNames := slices.Clip(deviceNames)
+			profileDevices := slices.Clip(devices)
 			//Generate the profile's personal devices
-			personDevicesCount := rand.Intn(5)
+			personDevicesCount := g.personalDeviceCount()
 			for i := 0; i < personDevicesCount; i++ {
-				deviceJSON, deviceSN, deviceName := GenerateDevice(false)
-				profileDeviceSNs = append(profileDeviceSNs, deviceSN)
-				profileDeviceNames = append(profileDeviceNames, deviceName)
-				var deviceBSON bson.D
-				err := bson.UnmarshalExtJSON([]byte(deviceJSON), true, &deviceBSON)
-				if err != nil {
-					log.Fatalf("Failed to convert Device JSON to BSON: %v", err)
-				}
-				deviceDocs = append(deviceDocs, deviceBSON)
+				device := g.GenerateDevice(false)
+				device.ID = g.objectID()
+				profileDeviceSNs = append(profileDeviceSNs, device.DeviceSN)
+				profileDeviceNames = append(profileDeviceNames, device.DeviceName)
+				profileDevices = append(profileDevices, device)
+				devicePending.add(layout.devices, device)
 			}
 			if i == 1 {
 				//Primary Profile
-				profile, primaryage = GenerateProfile(lastname, strconv.Itoa(i), "P", accountNum, address, 0, profileDeviceSNs, profileDeviceNames)
+				profile, primaryage = g.GenerateProfile(lastname, strconv.Itoa(i), "P", accountNum, address, 0, profileDeviceSNs, profileDeviceNames)
 			} else if i == 2 {
 				//Spouse of primary
 				var spouseage int
-				profile, spouseage = GenerateProfile(lastname, strconv.Itoa(i), "S", accountNum, address, primaryage, profileDeviceSNs, profileDeviceNames)
-				if spouseage < 64 && primaryage < 64 {
+				profile, spouseage = g.GenerateProfile(lastname, strconv.Itoa(i), "S", accountNum, address, primaryage, profileDeviceSNs, profileDeviceNames)
+				if !finalSize && spouseage < 64 && primaryage < 64 {
 					//expand family to include possible children
-					famSize = rand.Intn(5) + 2 //Between 2 and 6
+					famSize = g.Intn(5) + 2 //Between 2 and 6
 				}
 				if spouseage < primaryage {
 					primaryage = spouseage //this makes sure any children we generate aren't too old for one or both parents
 				}
 			} else {
 				//Child
-				profile, _ = GenerateProfile(lastname, strconv.Itoa(i), "C", accountNum, address, primaryage, profileDeviceSNs, profileDeviceNames)
+				profile, _ = g.GenerateProfile(lastname, strconv.Itoa(i), "C", accountNum, address, primaryage, profileDeviceSNs, profileDeviceNames)
 			}
-			//var profileBSON bson.D
-			//err := bson.UnmarshalExtJSON([]byte(profileJSON), true, &profileBSON)
-			//if err != nil {
-			//	log.Fatalf("Failed to convert Profile JSON String to BSON: %v", err)
-			//}
-			//profileDocs = append(profileDocs, profileBSON)
-			profileDocs = append(profileDocs, profile)
+			profilePending.add(true, layout.profileDoc(profile, profileDevices))
 
 			//Add mappings
-			for g := 0; g < len(profileDeviceSNs); g++ {
-				// Create a map representing the profile to device mapping
-				mappingData := map[string]interface{}{
-					"profileID": accountNum + "-" + strconv.Itoa(i),
-					"deviceSN":  profileDeviceSNs[g],
-				}
-				// Convert the map to a JSON string
-				mappingJSON, err := json.Marshal(mappingData)
-				if err != nil {
-					panic(err)
+			if layout.mappings {
+				for d := 0; d < len(profileDeviceSNs); d++ {
+					mappingPending.add(true, Mapping{
+						ID:        g.objectID(),
+						ProfileID: profile.ProfileID,
+						DeviceSN:  profileDeviceSNs[d],
+					})
 				}
-				var mappingBSON bson.D
-				err = bson.UnmarshalExtJSON([]byte(mappingJSON), true, &mappingBSON)
-				if err != nil {
-					log.Fatalf("Failed to convert Mapping JSON to BSON: %v", err)
-				}
-				mappingDocs = append(mappingDocs, mappingBSON)
-
+			}
+			if layout.buckets {
+				bucketPending.add(true, g.deviceBuckets(profile.ProfileID, profileDevices)...)
 			}
 
 			x++
@@ -256,65 +367,101 @@ func insertData(mdb *mongo.Database, wg *sync.WaitGroup, startProfile, endProfil
 			}
 		}
 
-		if len(deviceDocs) >= 10000 || x >= endProfile {
-			_, err := deviceColl.InsertMany(context.TODO(), deviceDocs, insertOrderedOp.SetOrdered(false))
-			if err != nil {
-				log.Print(err)
-			}
-			deviceDocs = nil
-			if appconfig.ConfigData.Debug {
-				log.Printf("Device document batch written")
+		//Skip the families a resumed, seeded load has already written
+		if x <= checkpoint.CompletedThrough {
+			for _, p := range pending {
+				p.docs = nil
 			}
+			continue
 		}
 
-		if len(profileDocs) >= 10000 || x >= endProfile {
-			_, err := profileColl.InsertMany(context.TODO(), profileDocs, insertOrderedOp.SetOrdered(false))
-			if err != nil {
-				log.Print(err)
-			}
-			profileDocs = nil
-			if appconfig.ConfigData.Debug {
-				log.Printf("Profile document batch written")
-			}
+		//The collections are written together, once a whole family has been generated, so that the
+		//checkpoint never lands part way through a family
+		full := x >= endProfile
+		for _, p := range pending {
+			full = full || len(p.docs) >= batchSize
 		}
-
-		if len(mappingDocs) >= 10000 || x >= endProfile {
-			_, err := mappingsColl.InsertMany(context.TODO(), mappingDocs, insertOrderedOp.SetOrdered(false))
-			if err != nil {
-				log.Print(err)
+		if full {
+			for _, p := range pending {
+				if err := p.write(ctx, sink); err != nil {
+					//An interrupted write is not a failure - the checkpoint just isn't advanced
+					if ctx.Err() == nil {
+						errs.Add(err)
+					}
+					return
+				}
 			}
-			mappingDocs = nil
-			if appconfig.ConfigData.Debug {
-				log.Printf("Mapping document batch written")
+
+			if err := sink.checkpoint(ctx, checkpoint.ID, x); err != nil {
+				if ctx.Err() == nil {
+					errs.Add(err)
+				}
+				return
 			}
 		}
 
 	}
 }
 
-// Function to retrieve a field value from bson.D
-func getFieldValue(doc bson.D, fieldName string) (interface{}, bool) {
-	for _, elem := range doc {
-		if elem.Key == fieldName {
-			return elem.Value, true
+// pendingDocs holds the documents generated for a collection that have not yet been written.
+type pendingDocs struct {
+	collName string
+	docs     []interface{}
+	count    *atomic.Int64 //Count of the documents written to the collection
+}
+
+// add queues documents to be written, if the schema variant writes them.
+func (p *pendingDocs) add(written bool, docs ...interface{}) {
+	if written {
+		p.docs = append(p.docs, docs...)
+	}
+}
+
+// write passes the pending documents to the sink.
+func (p *pendingDocs) write(ctx context.Context, sink documentSink) error {
+	if len(p.docs) == 0 {
+		return nil
+	}
+	written, err := sink.write(ctx, p.collName, p.docs)
+	if err != nil {
+		return err
+	}
+	p.count.Add(int64(written))
+	p.docs = nil
+	if appconfig.ConfigData.Debug {
+		log.Printf("%s document batch written", p.collName)
+	}
+	return nil
+}
+
+// insertMany writes a batch of documents without ordering, bounded by the operation timeout.
+func insertMany(ctx context.Context, coll *mongo.Collection, docs []interface{}, opts *options.InsertManyOptions) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	opCtx, cancel := common.OperationContext(ctx)
+	defer cancel()
+	if _, err := coll.InsertMany(opCtx, docs, opts.SetOrdered(false)); err != nil {
+		//Return the interruption itself, so the caller doesn't count the documents or report a failure
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
+		return fmt.Errorf("failed to write to %s: %w", coll.Name(), err)
 	}
-	return nil, false // Field not found
+	return nil
 }
 
-func runDataLoads(mdb *mongo.Database, wg *sync.WaitGroup, goRoutines, startID, customerCount int) {
+func runDataLoads(ctx context.Context, mdb *mongo.Database, wg *sync.WaitGroup, ranges []loadCheckpoint, errs *common.WorkerErrors) {
 
 	defer common.MasterWG.Done()
 
-	//Work out the number of members to be loaded by each goRoutine.
-	routineCustomerCount := customerCount / goRoutines
-
 	//Initialize the wait group
-	wg.Add(goRoutines)
+	wg.Add(len(ranges))
 
-	for i := 0; i < goRoutines; i++ {
-		go insertData(mdb, wg, startID, startID+routineCustomerCount)
-		startID += routineCustomerCount
+	//Start a goroutine for each range of profiles allocated to this connection
+	sink := newMongoSink(mdb, appconfig.ConfigData.ResumeLoad)
+	for _, checkpoint := range ranges {
+		go insertData(ctx, sink, wg, checkpoint, errs)
 	}
 	wg.Wait()
 
@@ -0,0 +1,41 @@
+package loaderservice
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"pipeline_blog/appconfig"
+	"pipeline_blog/common"
+)
+
+// BenchmarkInsertData measures how quickly the loader generates profiles, with their devices and mappings,
+// excluding the cost of writing them. Run it with go test -bench InsertData ./loaderservice.
+func BenchmarkInsertData(b *testing.B) {
+
+	b.Cleanup(func() {
+		appconfig.ConfigData = appconfig.AppConfig{}
+		ConfigureDistributions()
+	})
+	for _, variant := range appconfig.SchemaVariants {
+		b.Run(variant, func(b *testing.B) {
+			appconfig.ConfigData = appconfig.AppConfig{Seed: 42, SchemaVariant: variant}
+			if err := ConfigureDistributions(); err != nil {
+				b.Fatal(err)
+			}
+			resetCounts()
+			checkpoint := loadCheckpoint{ID: "1-" + strconv.Itoa(b.N+1), Start: 1, End: b.N + 1, CompletedThrough: 1}
+			var wg sync.WaitGroup
+			var errs common.WorkerErrors
+			wg.Add(1)
+			b.ResetTimer()
+			insertData(context.Background(), discardSink{}, &wg, checkpoint, &errs)
+			b.StopTimer()
+			if err := errs.Err(); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "profiles/s")
+		})
+	}
+}
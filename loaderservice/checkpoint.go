@@ -0,0 +1,190 @@
+package loaderservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"pipeline_blog/appconfig"
+	"pipeline_blog/common"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// checkpointColl records the progress of each data load worker, so an interrupted load can be resumed.
+const checkpointColl = "LoadCheckpoints"
+
+// loadCheckpoint records how far a single worker has got through its range of profile IDs.
+type loadCheckpoint struct {
+	ID               string    `bson:"_id"`              //The worker's range of profile IDs, "<Start>-<End>"
+	Start            int       `bson:"Start"`            //First profile ID in the range
+	End              int       `bson:"End"`              //Profile ID after the last one in the range
+	CompletedThrough int       `bson:"CompletedThrough"` //Profiles before this ID have been written
+	UpdatedAt        time.Time `bson:"UpdatedAt"`
+	//A load run without Seed is given a seed of its own, so that a resumed load can regenerate exactly the
+	//documents the earlier load generated. Zero for a seeded load.
+	LoadSeed int64 `bson:"LoadSeed,omitempty"`
+	//The time the loaded dates are relative to - SeededReferenceTime for a seeded load
+	ReferenceTime time.Time `bson:"ReferenceTime,omitempty"`
+}
+
+// generator returns the Generator for the worker's range of profiles.
+func (c loadCheckpoint) generator() *Generator {
+	stream := "load-" + c.ID
+	if c.LoadSeed != 0 {
+		return newSeededGenerator(c.LoadSeed, c.ReferenceTime, stream)
+	}
+	return NewGenerator(stream)
+}
+
+// seedLoad gives every range of an unseeded load the same seed and reference time, which are saved with the
+// checkpoints. The reference time is truncated to the millisecond precision of a BSON date, so that it is the
+// same once read back. A seeded load just records SeededReferenceTime, for ReferenceTime to read.
+func seedLoad(plan [][]loadCheckpoint) {
+
+	seed, now := int64(0), SeededReferenceTime
+	if appconfig.ConfigData.Seed == 0 {
+		seed = rand.Int63() | 1 //Never zero
+		now = time.Now().UTC().Truncate(time.Millisecond)
+	}
+	for _, ranges := range plan {
+		for i := range ranges {
+			ranges[i].LoadSeed = seed
+			ranges[i].ReferenceTime = now
+		}
+	}
+}
+
+// planLoad splits the profiles into a range of IDs for each goroutine on each connection.
+func planLoad(profiles, connectionCount, routineCount int) [][]loadCheckpoint {
+
+	plan := make([][]loadCheckpoint, connectionCount)
+	startID := 1
+	for i := 0; i < connectionCount; i++ {
+		//Work out the number of profiles to be loaded by this connection.
+		connectionProfiles := common.Share(profiles, connectionCount, i)
+		for j := 0; j < routineCount; j++ {
+			//Work out the number of members to be loaded by this goRoutine.
+			count := common.Share(connectionProfiles, routineCount, j)
+			if count == 0 {
+				continue
+			}
+			plan[i] = append(plan[i], loadCheckpoint{
+				ID:               strconv.Itoa(startID) + "-" + strconv.Itoa(startID+count),
+				Start:            startID,
+				End:              startID + count,
+				CompletedThrough: startID,
+			})
+			startID += count
+		}
+	}
+	return plan
+}
+
+// resetCheckpoints replaces any checkpoints left by an earlier load with those for a new load.
+func resetCheckpoints(ctx context.Context, mdb *mongo.Database, plan [][]loadCheckpoint) error {
+
+	seedLoad(plan)
+	ctx, cancel := common.OperationContext(ctx)
+	defer cancel()
+	coll := mdb.Collection(checkpointColl)
+	if err := coll.Drop(ctx); err != nil {
+		return fmt.Errorf("failed to drop %s: %w", checkpointColl, err)
+	}
+	var docs []interface{}
+	now := time.Now()
+	for _, ranges := range plan {
+		for _, checkpoint := range ranges {
+			checkpoint.UpdatedAt = now
+			docs = append(docs, checkpoint)
+		}
+	}
+	if _, err := coll.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("failed to create load checkpoints: %w", err)
+	}
+	return nil
+}
+
+// resumeCheckpoints reads the progress of an earlier load into the plan. The earlier load must have been
+// split into the same ranges, i.e. run with the same Profiles, Connections and GoRoutines settings.
+func resumeCheckpoints(ctx context.Context, mdb *mongo.Database, plan [][]loadCheckpoint) error {
+
+	ctx, cancel := common.OperationContext(ctx)
+	defer cancel()
+	cursor, err := mdb.Collection(checkpointColl).Find(ctx, bson.D{})
+	if err != nil {
+		return fmt.Errorf("failed to read load checkpoints: %w", err)
+	}
+	var saved []loadCheckpoint
+	if err := cursor.All(ctx, &saved); err != nil {
+		return fmt.Errorf("failed to decode load checkpoints: %w", err)
+	}
+	if len(saved) == 0 {
+		return fmt.Errorf("no load checkpoints found in %s - run the load without ResumeLoad", checkpointColl)
+	}
+	completed := make(map[string]loadCheckpoint, len(saved))
+	for _, checkpoint := range saved {
+		completed[checkpoint.ID] = checkpoint
+	}
+	mismatch := fmt.Errorf("the checkpoints in %s are for a different load - resume with the Profiles, Connections and GoRoutines settings used to start it", checkpointColl)
+	planned := 0
+	for _, ranges := range plan {
+		for i := range ranges {
+			checkpoint, ok := completed[ranges[i].ID]
+			if !ok {
+				return mismatch
+			}
+			ranges[i].CompletedThrough = checkpoint.CompletedThrough
+			ranges[i].LoadSeed = checkpoint.LoadSeed
+			ranges[i].ReferenceTime = checkpoint.ReferenceTime
+			planned++
+		}
+	}
+	if planned != len(saved) {
+		return mismatch
+	}
+	return nil
+}
+
+// ReferenceTime returns the time the dates in the loaded data set are relative to, as recorded in its load
+// checkpoints. Without checkpoints - e.g. for an imported data set - it is SeededReferenceTime if Seed is set,
+// otherwise the current time, which is only approximately the time the data was generated.
+func ReferenceTime(ctx context.Context, mdb *mongo.Database) (time.Time, error) {
+
+	ctx, cancel := common.OperationContext(ctx)
+	defer cancel()
+	var checkpoint loadCheckpoint
+	err := mdb.Collection(checkpointColl).FindOne(ctx, bson.D{}).Decode(&checkpoint)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, fmt.Errorf("failed to read load checkpoint: %w", err)
+	}
+	if !checkpoint.ReferenceTime.IsZero() {
+		return checkpoint.ReferenceTime, nil
+	}
+	if appconfig.ConfigData.Seed != 0 {
+		return SeededReferenceTime, nil
+	}
+	return time.Now(), nil
+}
+
+// saveCheckpoint records that every profile in a worker's range before completedThrough has been written.
+func saveCheckpoint(ctx context.Context, mdb *mongo.Database, rangeID string, completedThrough int) error {
+
+	ctx, cancel := common.OperationContext(ctx)
+	defer cancel()
+	updates := bson.D{
+		{"$set", bson.D{
+			{"CompletedThrough", completedThrough},
+			{"UpdatedAt", time.Now()},
+		}},
+	}
+	_, err := mdb.Collection(checkpointColl).UpdateOne(ctx, bson.D{{"_id", rangeID}}, updates)
+	if err != nil {
+		return fmt.Errorf("failed to save load checkpoint %s: %w", rangeID, err)
+	}
+	return nil
+}
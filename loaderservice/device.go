@@ -1,73 +1,91 @@
 package loaderservice
 
 import (
-	"encoding/json"
 	"fmt"
 	"math"
-	"math/rand"
 	"time"
 
 	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func GenerateDevice(shared bool) (string, string, string) {
+// Device is a document in the Devices collection.
+type Device struct {
+	ID                      primitive.ObjectID `bson:"_id,omitempty"`
+	DeviceSN                string             `bson:"deviceSN"`
+	DeviceName              string             `bson:"deviceName"`
+	LastIP4                 string             `bson:"lastIP4"`
+	LastIP6                 string             `bson:"lastIP6"`
+	LastSeenDate            time.Time          `bson:"lastSeenDate"`
+	AuthorizationExpiryDate time.Time          `bson:"authorizationExpiryDate"`
+	ParentalControls        bool               `bson:"parentalControls"`
+}
 
-	deviceSN := uuid.New()
-	deviceName := RandomDeviceName(shared)
-	deviceLastIP4 := generateRandomIPv4()
-	deviceLastIP6 := generateRandomIPv6()
-	deviceLastSeenDate := generateRandomDate(-45)
-	deviceAuthorizationExpiry := generateRandomDate(30)
-	deviceParentalControls := false
-	if rand.Intn(10) <= 3 {
-		deviceParentalControls = true
-	}
+// Mapping is a document in the Mappings collection, linking a profile to one of its devices.
+type Mapping struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	ProfileID string             `bson:"profileID"`
+	DeviceSN  string             `bson:"deviceSN"`
+}
 
-	deviceData := map[string]interface{}{
-		"deviceSN":                deviceSN.String(),
-		"deviceName":              deviceName,
-		"lastIP4":                 deviceLastIP4,
-		"lastIP6":                 deviceLastIP6,
-		"lastSeenDate":            deviceLastSeenDate,
-		"authorizationExpiryDate": deviceAuthorizationExpiry,
-		"parentalControls":        deviceParentalControls,
-	}
+func (g *Generator) GenerateDevice(shared bool) Device {
 
-	deviceJSON, err := json.Marshal(deviceData)
+	//Draw the UUID from the generator, so seeded loads reproduce it
+	deviceSN, err := uuid.NewRandomFromReader(g)
 	if err != nil {
 		panic(err)
 	}
-	return string(deviceJSON), deviceSN.String(), deviceName
-}
-
-func RandomDeviceName(shared bool) string {
-	sharedDeviceNames := []string{
-		"LG TV", "Samsung TV", "Sony TV", "Panasonic TV", "Vizio TV", "TCL TV", "Amazon Fire TV", "Apple TV", "Roku", "Hisense TV",
-		"Philips TV", "Sharp TV", "Insignia TV", "Toshiba TV", "Xiami TV", "OnePlus TV", "Skyworth TV", "JVC TV", "Element TV", "Sceptre TV",
-		"Haier TV", "Grundig TV",
+	deviceName := g.RandomDeviceName(shared)
+	deviceLastIP4 := g.generateRandomIPv4()
+	deviceLastIP6 := g.generateRandomIPv6()
+	deviceLastSeenDate := g.generateRandomDate(-45)
+	deviceAuthorizationExpiry := g.generateRandomDate(30)
+	deviceParentalControls := false
+	if g.Intn(10) <= 3 {
+		deviceParentalControls = true
 	}
 
-	personalDeviceNames := []string{
-		"PlayStation 3", "PlayStation 4", "PlayStation 5", "Xbox 360", "Xbox One", "Xbox S", "Xbox X", "Nintendo Switch", "iPhone 12",
-		"iPhone 13", "iPhone 14", "iPhone 15", "iPhone 16", "iPad", "iPad Mini", "iPad Air", "iPad Pro", "Amazon Fire Tablet", "Windows 10",
-		"Windows 11", "Mac OSX", "Chromebook", "Meta Quest", "Android Phone", "Linux PC", "Hisense TV",
+	return Device{
+		DeviceSN:                deviceSN.String(),
+		DeviceName:              deviceName,
+		LastIP4:                 deviceLastIP4,
+		LastIP6:                 deviceLastIP6,
+		LastSeenDate:            deviceLastSeenDate,
+		AuthorizationExpiryDate: deviceAuthorizationExpiry,
+		ParentalControls:        deviceParentalControls,
 	}
+}
+
+// The device names generated for shared and personal devices. The order matters for zipf distributions, where
+// the first name is the most popular.
+var sharedDeviceNames = []string{
+	"LG TV", "Samsung TV", "Sony TV", "Panasonic TV", "Vizio TV", "TCL TV", "Amazon Fire TV", "Apple TV", "Roku", "Hisense TV",
+	"Philips TV", "Sharp TV", "Insignia TV", "Toshiba TV", "Xiami TV", "OnePlus TV", "Skyworth TV", "JVC TV", "Element TV", "Sceptre TV",
+	"Haier TV", "Grundig TV",
+}
+
+var personalDeviceNames = []string{
+	"PlayStation 3", "PlayStation 4", "PlayStation 5", "Xbox 360", "Xbox One", "Xbox S", "Xbox X", "Nintendo Switch", "iPhone 12",
+	"iPhone 13", "iPhone 14", "iPhone 15", "iPhone 16", "iPad", "iPad Mini", "iPad Air", "iPad Pro", "Amazon Fire Tablet", "Windows 10",
+	"Windows 11", "Mac OSX", "Chromebook", "Meta Quest", "Android Phone", "Linux PC", "Hisense TV",
+}
 
+func (g *Generator) RandomDeviceName(shared bool) string {
 	if shared {
-		return sharedDeviceNames[rand.Intn(len(sharedDeviceNames))]
+		return sharedDeviceNames[sharedDeviceNameSampler.pick(g)]
 	} else {
-		return personalDeviceNames[rand.Intn(len(personalDeviceNames))]
+		return personalDeviceNames[personalDeviceNameSampler.pick(g)]
 	}
 }
 
 // generateRandomDate generates a random date within the last "daysRange" days
-func generateRandomDate(dayRange int) time.Time {
+func (g *Generator) generateRandomDate(dayRange int) time.Time {
 
-	// Get the current time
-	now := time.Now()
+	// Get the reference time - the current time unless the load is seeded
+	now := g.now
 
 	// Generate a random number of days (0 to 44)
-	daysAgo := rand.Intn(int(math.Abs(float64(dayRange))))
+	daysAgo := g.Intn(int(math.Abs(float64(dayRange))))
 
 	//Add (or subtract if the range was negative) the random number of days from the current time
 	if dayRange < 0 {
@@ -78,28 +96,25 @@ func generateRandomDate(dayRange int) time.Time {
 }
 
 // generateRandomIPv4 generates a random IPv4 address as a string
-func generateRandomIPv4() string {
-	// Seed the random number generator
-	rand.Seed(time.Now().UnixNano())
-
+func (g *Generator) generateRandomIPv4() string {
 	// Generate 4 random numbers between 0 and 255
-	octet1 := rand.Intn(256)
-	octet2 := rand.Intn(256)
-	octet3 := rand.Intn(256)
-	octet4 := rand.Intn(256)
+	octet1 := g.Intn(256)
+	octet2 := g.Intn(256)
+	octet3 := g.Intn(256)
+	octet4 := g.Intn(256)
 
 	// Format the numbers as an IPv4 address
 	return fmt.Sprintf("%d.%d.%d.%d", octet1, octet2, octet3, octet4)
 }
 
 // generateRandomIPv6 generates a random IPv6 address as a string
-func generateRandomIPv6() string {
+func (g *Generator) generateRandomIPv6() string {
 
 	// Generate 8 groups of 4 hex digits (16 bits each)
 	segments := make([]string, 8)
 	for i := 0; i < 8; i++ {
 		// Each segment is a random 16-bit value represented in hexadecimal
-		segments[i] = fmt.Sprintf("%x", rand.Intn(0x10000)) // 0x10000 = 65536
+		segments[i] = fmt.Sprintf("%x", g.Intn(0x10000)) // 0x10000 = 65536
 	}
 
 	// Join the segments with colons to form the IPv6 address
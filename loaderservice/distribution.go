@@ -0,0 +1,198 @@
+package loaderservice
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pipeline_blog/appconfig"
+)
+
+// sampler picks the index of a value from a list of n values, according to a Distribution.
+type sampler struct {
+	n          int
+	cumulative []float64 //Cumulative weight of each value. Nil for a uniform distribution.
+	configured bool      //A distribution was configured, rather than the default one applying
+}
+
+func (s sampler) pick(g *Generator) int {
+	if s.cumulative == nil {
+		return g.Intn(s.n)
+	}
+	x := g.Float64() * s.cumulative[len(s.cumulative)-1]
+	return sort.Search(len(s.cumulative), func(i int) bool { return s.cumulative[i] > x })
+}
+
+// The samplers for each generated field. They are uniform until ConfigureDistributions is called.
+var (
+	citySampler               = sampler{n: len(cities)}
+	sharedDeviceNameSampler   = sampler{n: len(sharedDeviceNames)}
+	personalDeviceNameSampler = sampler{n: len(personalDeviceNames)}
+	familySizeSampler         = sampler{n: 6}
+	sharedDevicesSampler      = sampler{n: 5}
+	personalDevicesSampler    = sampler{n: 5}
+)
+
+// The smallest of each generated count - the samplers pick an offset from these
+var (
+	minFamilySize      = appconfig.DefaultFamilySize.Min
+	minSharedDevices   = appconfig.DefaultSharedDevices.Min
+	minPersonalDevices = appconfig.DefaultPersonalDevices.Min
+)
+
+// familySize returns the number of profiles to generate in a family. Unless a FamilySize range or distribution
+// is configured, the size is only provisional - it is extended if the first two members are young enough to have
+// children.
+func (g *Generator) familySize() (size int, final bool) {
+	if !familySizeSampler.configured {
+		return g.Intn(2) + 1, false
+	}
+	return minFamilySize + familySizeSampler.pick(g), true
+}
+
+// sharedDeviceCount returns the number of devices to generate that are shared by a family.
+func (g *Generator) sharedDeviceCount() int {
+	return minSharedDevices + sharedDevicesSampler.pick(g)
+}
+
+// personalDeviceCount returns the number of devices to generate for a single profile.
+func (g *Generator) personalDeviceCount() int {
+	return minPersonalDevices + personalDevicesSampler.pick(g)
+}
+
+// ConfigureDistributions sets up the distributions of the generated fields from the Distributions setting, and
+// the ranges of the generated counts. It must be called before data or test parameters are generated.
+func ConfigureDistributions() error {
+
+	cfg := appconfig.ConfigData
+	dists := cfg.Distributions
+	cityNames := make([]string, len(cities))
+	for i, city := range cities {
+		cityNames[i] = city.City
+	}
+
+	var err error
+	if citySampler, err = newSampler("City", dists.City, cityNames, cityNames); err != nil {
+		return err
+	}
+	allDeviceNames := append(append([]string{}, sharedDeviceNames...), personalDeviceNames...)
+	if sharedDeviceNameSampler, err = newSampler("DeviceName", dists.DeviceName, sharedDeviceNames, allDeviceNames); err != nil {
+		return err
+	}
+	if personalDeviceNameSampler, err = newSampler("DeviceName", dists.DeviceName, personalDeviceNames, allDeviceNames); err != nil {
+		return err
+	}
+	familySizes := countValues(cfg.FamilySizeRange())
+	if familySizeSampler, err = newSampler("FamilySize", dists.FamilySize, familySizes, familySizes); err != nil {
+		return err
+	}
+	familySizeSampler.configured = familySizeSampler.configured || cfg.FamilySize != nil
+	sharedDevices := countValues(cfg.SharedDevicesRange())
+	if sharedDevicesSampler, err = newSampler("SharedDevices", dists.SharedDevices, sharedDevices, sharedDevices); err != nil {
+		return err
+	}
+	personalDevices := countValues(cfg.PersonalDevicesRange())
+	if personalDevicesSampler, err = newSampler("PersonalDevices", dists.PersonalDevices, personalDevices, personalDevices); err != nil {
+		return err
+	}
+	minFamilySize = cfg.FamilySizeRange().Min
+	minSharedDevices = cfg.SharedDevicesRange().Min
+	minPersonalDevices = cfg.PersonalDevicesRange().Min
+	return nil
+}
+
+// countValues returns the names of the counts in a range, e.g. "1", "2", "3".
+func countValues(r appconfig.Range) []string {
+	values := make([]string, r.Max-r.Min+1)
+	for i := range values {
+		values[i] = strconv.Itoa(r.Min + i)
+	}
+	return values
+}
+
+// newSampler builds the sampler for a list of values. Weights and tables may also name any of known, the values
+// of every list the distribution applies to - the values in other lists are ignored.
+func newSampler(field string, dist appconfig.Distribution, values, known []string) (sampler, error) {
+
+	s := sampler{n: len(values), configured: dist.Type != ""}
+	weights := make([]float64, len(values))
+	switch dist.Type {
+	case "", "uniform":
+		return s, nil
+	case "zipf":
+		//Popularity falls with the value's position in the list
+		for i := range weights {
+			weights[i] = 1 / math.Pow(float64(i+1), dist.Exponent)
+		}
+	case "weighted", "table":
+		named := dist.Weights
+		defaultWeight := 1.0
+		if dist.Type == "table" {
+			var err error
+			if named, err = readFrequencyTable(dist.File); err != nil {
+				return s, fmt.Errorf("Distributions.%s: %w", field, err)
+			}
+			defaultWeight = 0
+		}
+		for name := range named {
+			if !slices.Contains(known, name) {
+				return s, fmt.Errorf("Distributions.%s: %q is not one of the generated values", field, name)
+			}
+		}
+		for i, value := range values {
+			weights[i] = defaultWeight
+			if weight, ok := named[value]; ok {
+				weights[i] = weight
+			}
+		}
+	default:
+		return s, fmt.Errorf("Distributions.%s: unknown distribution type %q", field, dist.Type)
+	}
+
+	s.cumulative = make([]float64, len(weights))
+	total := 0.0
+	for i, weight := range weights {
+		total += weight
+		s.cumulative[i] = total
+	}
+	if total <= 0 {
+		return s, fmt.Errorf("Distributions.%s: every value has a weight of zero", field)
+	}
+	return s, nil
+}
+
+// readFrequencyTable reads a CSV file of value,frequency rows. The frequencies are relative, so they can be
+// counts, percentages or fractions.
+func readFrequencyTable(path string) (map[string]float64, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	reader.Comment = '#'
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("frequency table %s: %w", path, err)
+	}
+	table := make(map[string]float64, len(rows))
+	for i, row := range rows {
+		frequency, err := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		if err != nil || frequency < 0 {
+			//Allow a header row
+			if i == 0 && err != nil {
+				continue
+			}
+			return nil, fmt.Errorf("frequency table %s: invalid frequency %q for %q", path, row[1], row[0])
+		}
+		table[strings.TrimSpace(row[0])] += frequency
+	}
+	return table, nil
+}
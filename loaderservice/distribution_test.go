@@ -0,0 +1,140 @@
+package loaderservice
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pipeline_blog/appconfig"
+)
+
+func TestNewSampler(t *testing.T) {
+
+	values := []string{"a", "b", "c", "d"}
+	known := []string{"a", "b", "c", "d", "e"}
+	tests := []struct {
+		name           string
+		dist           appconfig.Distribution
+		table          string    //Contents of the table file, written to dist.File
+		wantCumulative []float64 //Nil for a uniform sampler
+		wantErr        bool
+	}{
+		{name: "default", dist: appconfig.Distribution{}},
+		{name: "uniform", dist: appconfig.Distribution{Type: "uniform"}},
+		{
+			name:           "zipf",
+			dist:           appconfig.Distribution{Type: "zipf", Exponent: 1},
+			wantCumulative: []float64{1, 1 + 1.0/2, 1 + 1.0/2 + 1.0/3, 1 + 1.0/2 + 1.0/3 + 1.0/4},
+		},
+		{
+			name:           "zipf exponent 2",
+			dist:           appconfig.Distribution{Type: "zipf", Exponent: 2},
+			wantCumulative: []float64{1, 1 + 1.0/4, 1 + 1.0/4 + 1.0/9, 1 + 1.0/4 + 1.0/9 + 1.0/16},
+		},
+		{
+			//Unlisted values have a weight of 1, and values only in another list are ignored
+			name:           "weighted",
+			dist:           appconfig.Distribution{Type: "weighted", Weights: map[string]float64{"b": 3, "c": 0, "e": 5}},
+			wantCumulative: []float64{1, 4, 4, 5},
+		},
+		{
+			name:    "weighted all zero",
+			dist:    appconfig.Distribution{Type: "weighted", Weights: map[string]float64{"a": 0, "b": 0, "c": 0, "d": 0}},
+			wantErr: true,
+		},
+		{
+			name:    "weighted unknown value",
+			dist:    appconfig.Distribution{Type: "weighted", Weights: map[string]float64{"z": 1}},
+			wantErr: true,
+		},
+		{
+			//Unlisted values are never generated
+			name:           "table",
+			dist:           appconfig.Distribution{Type: "table"},
+			table:          "value,frequency\n# comment\nd, 2\nb,0.5\nd,1\n",
+			wantCumulative: []float64{0, 0.5, 0.5, 3.5},
+		},
+		{
+			name:    "table of zeros",
+			dist:    appconfig.Distribution{Type: "table"},
+			table:   "a,0\n",
+			wantErr: true,
+		},
+		{
+			name:    "table with negative frequency",
+			dist:    appconfig.Distribution{Type: "table"},
+			table:   "a,1\nb,-1\n",
+			wantErr: true,
+		},
+		{
+			name:    "table with invalid frequency",
+			dist:    appconfig.Distribution{Type: "table"},
+			table:   "a,1\nb,many\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing table",
+			dist:    appconfig.Distribution{Type: "table", File: filepath.Join(t.TempDir(), "missing.csv")},
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			dist:    appconfig.Distribution{Type: "normal"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.table != "" {
+				test.dist.File = filepath.Join(t.TempDir(), "table.csv")
+				if err := os.WriteFile(test.dist.File, []byte(test.table), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s, err := newSampler("Test", test.dist, values, known)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("newSampler() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newSampler() failed: %v", err)
+			}
+			if s.n != len(values) || s.configured != (test.dist.Type != "") {
+				t.Errorf("newSampler() = %+v", s)
+			}
+			if len(s.cumulative) != len(test.wantCumulative) {
+				t.Fatalf("newSampler() cumulative weights = %v, want %v", s.cumulative, test.wantCumulative)
+			}
+			for i := range s.cumulative {
+				if math.Abs(s.cumulative[i]-test.wantCumulative[i]) > 1e-12 {
+					t.Errorf("newSampler() cumulative weights = %v, want %v", s.cumulative, test.wantCumulative)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSamplerNeverPicksZeroWeights(t *testing.T) {
+
+	dist := appconfig.Distribution{Type: "weighted", Weights: map[string]float64{"a": 0, "c": 0, "d": 2}}
+	s, err := newSampler("Test", dist, []string{"a", "b", "c", "d"}, []string{"a", "b", "c", "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := newSeededGenerator(1, SeededReferenceTime, "test")
+	picks := make([]int, s.n)
+	for i := 0; i < 30000; i++ {
+		picks[s.pick(g)]++
+	}
+	if picks[0] != 0 || picks[2] != 0 {
+		t.Errorf("zero weight values were picked: %v", picks)
+	}
+	//d is twice as likely as b
+	if ratio := float64(picks[3]) / float64(picks[1]); ratio < 1.8 || ratio > 2.2 {
+		t.Errorf("picks = %v, want d picked about twice as often as b", picks)
+	}
+}
@@ -0,0 +1,304 @@
+package loaderservice
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pipeline_blog/appconfig"
+	"pipeline_blog/common"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// ExportData generates the collections for the configured schema variant and writes them to a file per collection
+// in ExportPath rather than to MongoDB, so no database is needed. The files are written in ExportFormat:
+//
+//   - json: one Extended JSON document per line, as read by mongoimport
+//   - bson: the mongodump format read by mongorestore, with a metadata file per collection defining its indexes
+//   - csv: a column per field, with embedded documents flattened into dotted field names as mongoexport does
+//   - discard: nothing is written, so the time taken measures the cost of generating the documents alone
+//
+// The data set is split between the workers exactly as LoadData splits it, so with the same Seed, Profiles,
+// Connections and GoRoutines settings an export holds the same documents as a load.
+func ExportData(ctx context.Context) error {
+
+	dir := appconfig.ConfigData.ExportPath()
+	format := appconfig.ConfigData.ExportFileFormat()
+	log.Printf("Data Export Started - writing %s files to %s", format, dir)
+
+	resetCounts()
+
+	var sink interface {
+		documentSink
+		close() error
+	}
+	if format == "discard" {
+		sink = discardSink{}
+	} else {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create export directory: %w", err)
+		}
+		fileSink, err := newFileSink(dir, format)
+		if err != nil {
+			return err
+		}
+		sink = fileSink
+	}
+
+	//Start a goroutine for each range of profiles, as the load would
+	var wg sync.WaitGroup
+	var exportErrs common.WorkerErrors
+	startTime := time.Now()
+	for _, ranges := range planLoad(appconfig.ConfigData.Profiles, appconfig.ConfigData.Connections, appconfig.ConfigData.GoRoutines) {
+		wg.Add(len(ranges))
+		for _, checkpoint := range ranges {
+			go insertData(ctx, sink, &wg, checkpoint, &exportErrs)
+		}
+	}
+	wg.Wait()
+	duration := time.Since(startTime)
+
+	//Always close the files, so whatever was generated before a failure is flushed
+	if err := errors.Join(exportErrs.Err(), sink.close()); err != nil {
+		return fmt.Errorf("data export failed after %d profiles: %w", profilesGenerated.Load(), err)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("data export interrupted after %d profiles: %w", profilesGenerated.Load(), ctx.Err())
+	}
+	if format == "bson" {
+		if err := writeMetadata(dir); err != nil {
+			return err
+		}
+	}
+	profiles := profilesGenerated.Load()
+	docs := profiles + devicesGenerated.Load() + mappingsGenerated.Load() + bucketsGenerated.Load()
+	log.Printf("Data Export Completed (%s) in %v - %.0f profiles/s, %.0f documents/s", countsSummary(),
+		duration.Round(time.Millisecond), float64(profiles)/duration.Seconds(), float64(docs)/duration.Seconds())
+	return nil
+}
+
+// discardSink drops the generated documents, for measuring generation throughput.
+type discardSink struct{}
+
+func (discardSink) write(ctx context.Context, collName string, docs []interface{}) (int, error) {
+	return len(docs), nil
+}
+
+func (discardSink) checkpoint(ctx context.Context, rangeID string, completedThrough int) error {
+	return nil
+}
+
+func (discardSink) close() error {
+	return nil
+}
+
+// fileSink writes the generated documents to a file per collection. Exports are not checkpointed.
+type fileSink struct {
+	files map[string]*exportFile
+}
+
+func newFileSink(dir, format string) (*fileSink, error) {
+
+	sink := &fileSink{files: make(map[string]*exportFile)}
+	for _, collName := range Collections() {
+		file, err := os.Create(filepath.Join(dir, collName+"."+format))
+		if err != nil {
+			sink.close()
+			return nil, fmt.Errorf("failed to create export file: %w", err)
+		}
+		sink.files[collName] = newExportFile(file, format)
+	}
+	return sink, nil
+}
+
+func (s *fileSink) write(ctx context.Context, collName string, docs []interface{}) (int, error) {
+	if err := s.files[collName].write(docs); err != nil {
+		return 0, fmt.Errorf("failed to export %s: %w", collName, err)
+	}
+	return len(docs), nil
+}
+
+func (s *fileSink) checkpoint(ctx context.Context, rangeID string, completedThrough int) error {
+	return nil
+}
+
+// close flushes and closes every file.
+func (s *fileSink) close() error {
+	var errs []error
+	for _, file := range s.files {
+		errs = append(errs, file.close())
+	}
+	return errors.Join(errs...)
+}
+
+// exportFile writes a collection's documents to a file. The workers write whole batches in turn.
+type exportFile struct {
+	mu      sync.Mutex
+	file    *os.File
+	buf     *bufio.Writer
+	format  string
+	csv     *csv.Writer
+	columns []string //CSV header, taken from the fields of the first document
+}
+
+func newExportFile(file *os.File, format string) *exportFile {
+	f := &exportFile{file: file, buf: bufio.NewWriterSize(file, 1<<20), format: format}
+	if format == "csv" {
+		f.csv = csv.NewWriter(f.buf)
+	}
+	return f
+}
+
+func (f *exportFile) write(docs []interface{}) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, doc := range docs {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		switch f.format {
+		case "bson":
+			_, err = f.buf.Write(data)
+		case "json":
+			var line []byte
+			if line, err = bson.MarshalExtJSON(bson.Raw(data), true, false); err == nil {
+				line = append(line, '\n')
+				_, err = f.buf.Write(line)
+			}
+		case "csv":
+			err = f.writeRow(bson.Raw(data))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if f.csv != nil {
+		f.csv.Flush()
+		return f.csv.Error()
+	}
+	return nil
+}
+
+// writeRow writes a document as a CSV row, first writing the header if this is the first document.
+func (f *exportFile) writeRow(doc bson.Raw) error {
+
+	var columns, row []string
+	if err := flatten("", doc, &columns, &row); err != nil {
+		return err
+	}
+	if f.columns == nil {
+		f.columns = columns
+		if err := f.csv.Write(columns); err != nil {
+			return err
+		}
+	} else if strings.Join(columns, ",") != strings.Join(f.columns, ",") {
+		return fmt.Errorf("document fields %v do not match the CSV columns %v", columns, f.columns)
+	}
+	return f.csv.Write(row)
+}
+
+func (f *exportFile) close() error {
+	var flushErr error
+	if f.csv != nil {
+		f.csv.Flush()
+		flushErr = f.csv.Error()
+	}
+	return errors.Join(flushErr, f.buf.Flush(), f.file.Close())
+}
+
+// flatten appends a column name and value for each field of a document. The fields of embedded documents are
+// named by their dotted path, while arrays are written to a single column as Extended JSON.
+func flatten(prefix string, doc bson.Raw, columns, row *[]string) error {
+
+	elements, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+	for _, element := range elements {
+		name := prefix + element.Key()
+		value := element.Value()
+		if value.Type == bsontype.EmbeddedDocument {
+			if err := flatten(name+".", value.Document(), columns, row); err != nil {
+				return err
+			}
+			continue
+		}
+		*columns = append(*columns, name)
+		*row = append(*row, csvValue(value))
+	}
+	return nil
+}
+
+func csvValue(value bson.RawValue) string {
+	switch value.Type {
+	case bsontype.String:
+		return value.StringValue()
+	case bsontype.ObjectID:
+		return value.ObjectID().Hex()
+	case bsontype.DateTime:
+		return value.Time().UTC().Format(time.RFC3339Nano)
+	case bsontype.Boolean:
+		return strconv.FormatBool(value.Boolean())
+	case bsontype.Int32:
+		return strconv.Itoa(int(value.Int32()))
+	case bsontype.Int64:
+		return strconv.FormatInt(value.Int64(), 10)
+	case bsontype.Double:
+		return strconv.FormatFloat(value.Double(), 'g', -1, 64)
+	case bsontype.Null:
+		return ""
+	default:
+		return value.String()
+	}
+}
+
+// writeMetadata writes the mongodump metadata file for each collection, so that mongorestore creates the
+// indexes the pipeline tests use along with the data.
+func writeMetadata(dir string) error {
+
+	for _, collName := range Collections() {
+		indexes := bson.A{bson.D{{"v", 2}, {"key", bson.D{{"_id", 1}}}, {"name", "_id_"}}}
+		for _, index := range schemaIndexes() {
+			if index.collName != collName {
+				continue
+			}
+			keys := index.indexModel.Keys.(bson.D)
+			spec := bson.D{{"v", 2}, {"key", keys}, {"name", indexName(keys)}}
+			if opts := index.indexModel.Options; opts != nil && opts.Hidden != nil && *opts.Hidden {
+				spec = append(spec, bson.E{"hidden", true})
+			}
+			indexes = append(indexes, spec)
+		}
+		metadata := bson.D{{"indexes", indexes}, {"collectionName", collName}, {"type", "collection"}}
+		data, err := bson.MarshalExtJSON(metadata, true, false)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, collName+".metadata.json"), data, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s metadata: %w", collName, err)
+		}
+	}
+	return nil
+}
+
+// indexName returns the name MongoDB gives an index with the given keys by default, e.g. "profileID_1".
+func indexName(keys bson.D) string {
+	parts := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
@@ -0,0 +1,71 @@
+package loaderservice
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"pipeline_blog/appconfig"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SeededReferenceTime is the time generated dates are relative to when Seed is set, so that a seeded load
+// produces the same data whenever it is run. Unseeded loads use the current time.
+var SeededReferenceTime = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Generator produces random test data. rand.Rand is not safe for concurrent use, so each goroutine must
+// have its own Generator.
+type Generator struct {
+	*rand.Rand
+	now    time.Time //Generated dates are relative to this time
+	seeded bool
+}
+
+// NewGenerator returns a Generator for the named stream of random values, e.g. one worker's range of profiles.
+// If Seed is set, the values are determined by the seed and the stream name alone, so the same seed always
+// reproduces the same data.
+func NewGenerator(stream string) *Generator {
+
+	seed := appconfig.ConfigData.Seed
+	if seed == 0 {
+		return &Generator{
+			Rand: rand.New(rand.NewSource(time.Now().UnixNano() ^ streamHash(0, stream))),
+			now:  time.Now(),
+		}
+	}
+	return newSeededGenerator(seed, SeededReferenceTime, stream)
+}
+
+// newSeededGenerator returns a Generator whose values are determined by the seed and stream name, with dates
+// relative to now.
+func newSeededGenerator(seed int64, now time.Time, stream string) *Generator {
+	return &Generator{
+		Rand:   rand.New(rand.NewSource(streamHash(seed, stream))),
+		now:    now,
+		seeded: true,
+	}
+}
+
+// streamHash derives the seed for a stream from the configured seed.
+func streamHash(seed int64, stream string) int64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, seed)
+	h.Write([]byte(stream))
+	return int64(h.Sum64())
+}
+
+// objectID returns a generated _id for a document when the Generator is seeded - by Seed, or by the seed an
+// unseeded load saves with its checkpoints - so that the _ids are reproduced along with the rest of the data.
+// Otherwise it returns the nil ObjectID. The _id of every generated document is tagged omitempty, so the driver
+// then assigns it.
+func (g *Generator) objectID() primitive.ObjectID {
+	var id primitive.ObjectID
+	if !g.seeded {
+		return id
+	}
+	binary.BigEndian.PutUint32(id[0:4], uint32(g.now.Unix()))
+	g.Read(id[4:])
+	return id
+}
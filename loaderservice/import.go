@@ -0,0 +1,251 @@
+package loaderservice
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pipeline_blog/appconfig"
+	"pipeline_blog/common"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// importBatch is a batch of documents read from a collection's file.
+type importBatch struct {
+	collName string
+	docs     []interface{}
+}
+
+// ImportData reads the collections for the configured schema variant from the files written by ExportData, in
+// ExportPath and ExportFormat, replacing the existing collections. A reader goroutine for each file passes
+// batches of documents to GoRoutines writers on each connection, as LoadData does with generated documents,
+// and the indexes are then created. The import is recorded in the results collection like a data load.
+func ImportData(ctx context.Context) error {
+
+	dir := appconfig.ConfigData.ExportPath()
+	format := appconfig.ConfigData.ExportFileFormat()
+	if format != "json" && format != "bson" {
+		return fmt.Errorf("cannot import %s files - set ExportFormat to json or bson", format)
+	}
+	log.Printf("Data Import Started - reading %s files from %s", format, dir)
+
+	connectionCount := appconfig.ConfigData.Connections
+	routineCount := appconfig.ConfigData.GoRoutines
+	resetCounts()
+
+	//Open every file before anything is dropped
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	colls := Collections()
+	for _, collName := range colls {
+		file, err := os.Open(filepath.Join(dir, collName+"."+format))
+		if err != nil {
+			return fmt.Errorf("failed to open %s data - export the data set first: %w", collName, err)
+		}
+		files = append(files, file)
+	}
+
+	connections, err := openConnections(ctx, connectionCount)
+	defer closeConnections(ctx, connections)
+	if err != nil {
+		return err
+	}
+
+	//An imported data set has no checkpoints, so remove any left by an earlier load along with the data
+	dropCollections(ctx, connections[0])
+	dropCtx, cancel := common.OperationContext(ctx)
+	connections[0].Collection(checkpointColl).Drop(dropCtx)
+	cancel()
+
+	//Stop reading and writing as soon as anything fails
+	importCtx, cancelImport := context.WithCancel(ctx)
+	defer cancelImport()
+	var importErrs common.WorkerErrors
+	fail := func(err error) {
+		importErrs.Add(err)
+		cancelImport()
+	}
+
+	startTime := time.Now()
+	batches := make(chan importBatch, connectionCount*routineCount)
+	var readers sync.WaitGroup
+	readers.Add(len(files))
+	for i, file := range files {
+		go func(collName string, file *os.File) {
+			defer readers.Done()
+			if err := readFile(importCtx, collName, file, format, batches); err != nil {
+				fail(err)
+			}
+		}(colls[i], file)
+	}
+	go func() {
+		readers.Wait()
+		close(batches)
+	}()
+
+	//Start a new Go Routine for each MDB connection
+	var wgs []*sync.WaitGroup
+	for i := 0; i < connectionCount; i++ {
+		var wg sync.WaitGroup
+		wgs = append(wgs, &wg)
+	}
+	common.MasterWG.Add(connectionCount)
+	for i := 0; i < connectionCount; i++ {
+		go runImports(importCtx, connections[i], wgs[i], routineCount, batches, fail)
+	}
+	common.MasterWG.Wait()
+	endTime := time.Now()
+	importErr := importErrs.Err()
+	aborted := importErr != nil || ctx.Err() != nil
+
+	result := loadResult("Pipeline Blog Data Import", startTime, endTime, aborted)
+	if err := saveLoadResult(ctx, connections[0], result); err != nil {
+		return err
+	}
+	if importErr != nil {
+		return fmt.Errorf("data import failed after %d profiles: %w", result.ProfileCount, importErr)
+	}
+	if aborted {
+		return fmt.Errorf("data import interrupted after %d profiles: %w", result.ProfileCount, ctx.Err())
+	}
+	log.Printf("Data Import Completed (%s) - creating Indexes", countsSummary())
+
+	return createIndexes(ctx, connections[0])
+}
+
+// runImports starts goRoutines writers on a connection, each of which writes batches until none are left.
+func runImports(ctx context.Context, mdb *mongo.Database, wg *sync.WaitGroup, goRoutines int, batches <-chan importBatch, fail func(error)) {
+
+	defer common.MasterWG.Done()
+
+	wg.Add(goRoutines)
+	sink := newMongoSink(mdb, false)
+	for i := 0; i < goRoutines; i++ {
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				//Keep draining the channel once the import has stopped, so the readers aren't left blocked
+				if ctx.Err() != nil {
+					continue
+				}
+				written, err := sink.write(ctx, batch.collName, batch.docs)
+				if err != nil {
+					//An interrupted write is not a failure, and its documents aren't counted
+					if ctx.Err() == nil {
+						fail(err)
+					}
+					continue
+				}
+				importCounter(batch.collName).Add(int64(written))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// importCounter returns the count of documents written to a collection.
+func importCounter(collName string) *atomic.Int64 {
+	switch collName {
+	case "Profiles":
+		return &profilesGenerated
+	case "Devices":
+		return &devicesGenerated
+	case "Mappings":
+		return &mappingsGenerated
+	default:
+		return &bucketsGenerated
+	}
+}
+
+// readFile reads a collection's documents from an Extended JSON or BSON file, sending them out in batches.
+func readFile(ctx context.Context, collName string, file *os.File, format string, batches chan<- importBatch) error {
+
+	next := nextJSONDocument
+	if format == "bson" {
+		next = nextBSONDocument
+	}
+	reader := bufio.NewReaderSize(file, 1<<20)
+	batchSize := appconfig.ConfigData.InsertBatch()
+	docs := make([]interface{}, 0, batchSize)
+	for {
+		doc, err := next(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Name(), err)
+		}
+		docs = append(docs, doc)
+		if len(docs) < batchSize {
+			continue
+		}
+		select {
+		case batches <- importBatch{collName: collName, docs: docs}:
+		case <-ctx.Done():
+			return nil
+		}
+		docs = make([]interface{}, 0, batchSize)
+	}
+	if len(docs) > 0 {
+		select {
+		case batches <- importBatch{collName: collName, docs: docs}:
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
+
+// nextJSONDocument reads the next document from a file holding one Extended JSON document per line. Both the
+// canonical form written by export mode and the relaxed form written by mongoexport are accepted.
+func nextJSONDocument(reader *bufio.Reader) (bson.Raw, error) {
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			var doc bson.Raw
+			if jsonErr := bson.UnmarshalExtJSON(line, false, &doc); jsonErr != nil {
+				return nil, jsonErr
+			}
+			return doc, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// nextBSONDocument reads the next document from a file of concatenated BSON documents, as written by mongodump.
+func nextBSONDocument(reader *bufio.Reader) (bson.Raw, error) {
+
+	var header [4]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	length := int(binary.LittleEndian.Uint32(header[:]))
+	if length < 5 {
+		return nil, fmt.Errorf("invalid BSON document length %d", length)
+	}
+	doc := make(bson.Raw, length)
+	copy(doc, header[:])
+	if _, err := io.ReadFull(reader, doc[4:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return doc, doc.Validate()
+}
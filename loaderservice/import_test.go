@@ -0,0 +1,44 @@
+package loaderservice
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNextJSONDocument(t *testing.T) {
+
+	//The same document in canonical Extended JSON, as written by export mode, and relaxed, as written by mongoexport
+	lines := []string{
+		`{"profileID":"A-1","count":{"$numberInt":"3"},"seen":{"$date":{"$numberLong":"1735689600000"}},"score":{"$numberDouble":"2.5"}}`,
+		``,
+		`{"profileID":"A-1","count":3,"seen":{"$date":"2025-01-01T00:00:00Z"},"score":2.5}`,
+	}
+	reader := bufio.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	for i := 0; i < 2; i++ {
+		doc, err := nextJSONDocument(reader)
+		if err != nil {
+			t.Fatalf("document %d: %v", i, err)
+		}
+		var got struct {
+			ProfileID string    `bson:"profileID"`
+			Count     int32     `bson:"count"`
+			Seen      time.Time `bson:"seen"`
+			Score     float64   `bson:"score"`
+		}
+		if err := bson.Unmarshal(doc, &got); err != nil {
+			t.Fatalf("document %d: %v", i, err)
+		}
+		if got.ProfileID != "A-1" || got.Count != 3 || !got.Seen.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) || got.Score != 2.5 {
+			t.Errorf("document %d = %+v", i, got)
+		}
+	}
+	if _, err := nextJSONDocument(reader); !errors.Is(err, io.EOF) {
+		t.Errorf("nextJSONDocument() at end of file = %v, want io.EOF", err)
+	}
+}
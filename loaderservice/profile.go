@@ -2,9 +2,10 @@ package loaderservice
 
 import (
 	"fmt"
-	"math/rand"
 	"strconv"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type DeviceData struct {
@@ -12,42 +13,67 @@ type DeviceData struct {
 	DeviceName string `bson:"deviceName"`
 }
 
+// AddressData is a struct rather than a map so its fields are always written in the same order.
+type AddressData struct {
+	City      string `bson:"city"`
+	StateCode string `bson:"stateCode"`
+	ZipCode   string `bson:"zipCode"`
+	Street    string `bson:"street"`
+}
+
 type ContactData struct {
-	Address     map[string]string `bson:"address"`
-	PhoneNumber string            `bson:"phoneNumber"`
+	Address     AddressData `bson:"address"`
+	PhoneNumber string      `bson:"phoneNumber"`
 }
 
+// Profile is a generated profile, holding every field stored by the superset schema variant. The other variants
+// store the person and contact fields along with their own device fields (see profileDoc).
 type Profile struct {
-	LastName     string       `bson:"lastName"`
-	FirstName    string       `bson:"firstName"`
-	DOB          time.Time    `bson:"DOB"`
-	SSN          string       `bson:"SSN"`
-	AccountNum   string       `bson:"accountNum"`
-	ProfileID    string       `bson:"profileID"`
-	DeviceSNs    []string     `bson:"deviceSNs"`
-	Devices      []DeviceData `bson:"devices"`
-	Contact      ContactData  `bson:"contact"`
-	CustomerType string       `bson:"customerType"`
+	PersonFields  `bson:",inline"`
+	DeviceSNs     []string     `bson:"deviceSNs"`
+	Devices       []DeviceData `bson:"devices"`
+	ContactFields `bson:",inline"`
+}
+
+// PersonFields are the fields stored before a profile's devices.
+type PersonFields struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	LastName   string             `bson:"lastName"`
+	FirstName  string             `bson:"firstName"`
+	DOB        time.Time          `bson:"DOB"`
+	SSN        string             `bson:"SSN"`
+	AccountNum string             `bson:"accountNum"`
+	ProfileID  string             `bson:"profileID"`
+}
+
+// ContactFields are the fields stored after a profile's devices.
+type ContactFields struct {
+	Contact      ContactData `bson:"contact"`
+	CustomerType string      `bson:"customerType"`
 }
 
-func GenerateProfile(lastName, familyID, personType, accountNum string, address map[string]string, primaryAge int, deviceSNs, deviceNames []string) (Profile, int) {
+func (g *Generator) GenerateProfile(lastName, familyID, personType, accountNum string, address AddressData, primaryAge int, deviceSNs, deviceNames []string) (Profile, int) {
 
 	profileID := accountNum + "-" + familyID
 
-	firstName := randomFirstName()
-	ssn := randomSSN()
-	phoneNumber := randomPhoneNumber()
+	firstName := g.randomFirstName()
+	ssn := g.randomSSN()
+	phoneNumber := g.randomPhoneNumber()
 
 	var dob DateOfBirth
 	if personType == "P" {
-		dob, _ = generatePrimaryDOB()
+		dob, _ = g.generatePrimaryDOB()
 	} else if personType == "S" {
-		dob, _ = generateSpouseDOB(primaryAge)
+		dob, _ = g.generateSpouseDOB(primaryAge)
 	} else {
-		dob, _ = generateChildDOB(primaryAge)
+		dob, _ = g.generateChildDOB(primaryAge)
 	}
 
-	var devices []DeviceData
+	//A profile without devices stores empty arrays rather than nulls
+	if deviceSNs == nil {
+		deviceSNs = []string{}
+	}
+	devices := make([]DeviceData, 0, len(deviceSNs))
 	for index, sn := range deviceSNs {
 		var device DeviceData
 		device.DeviceSN = sn
@@ -61,6 +87,7 @@ func GenerateProfile(lastName, familyID, personType, accountNum string, address
 
 	// Create the profile data
 	var profile Profile
+	profile.ID = g.objectID()
 	profile.ProfileID = profileID
 	profile.AccountNum = accountNum
 	profile.FirstName = firstName
@@ -76,7 +103,7 @@ func GenerateProfile(lastName, familyID, personType, accountNum string, address
 }
 
 // Generate a random first name from a predefined list of 500 names
-func randomFirstName() string {
+func (g *Generator) randomFirstName() string {
 	firstNames := []string{
 		"Aaron", "Abby", "Abigail", "Adam", "Adrian", "Aiden", "Alex", "Alexa", "Alexander", "Alexis",
 		"Alice", "Alicia", "Alison", "Amanda", "Amber", "Amelia", "Amy", "Andrea", "Andrew", "Angela",
@@ -114,20 +141,20 @@ func randomFirstName() string {
 		// Extend this array to 500 names if required
 	}
 
-	return firstNames[rand.Intn(len(firstNames))]
+	return firstNames[g.Intn(len(firstNames))]
 }
 
-func randomAccountIDBase() string {
+func (g *Generator) randomAccountIDBase() string {
 	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	result := make([]byte, 10)
 	for i := range result {
-		result[i] = charset[rand.Intn(len(charset))]
+		result[i] = charset[g.Intn(len(charset))]
 	}
 	return string(result)
 }
 
 // Generate a random last name from a predefined list of 500 names
-func RandomLastName() string {
+func (g *Generator) RandomLastName() string {
 	lastNames := []string{
 		"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez",
 		"Hernandez", "Lopez", "Gonzalez", "Wilson", "Anderson", "Thomas", "Taylor", "Moore", "Jackson", "Martin",
@@ -150,11 +177,11 @@ func RandomLastName() string {
 		// Extend this array to 500 names as needed
 	}
 
-	return lastNames[rand.Intn(len(lastNames))]
+	return lastNames[g.Intn(len(lastNames))]
 }
 
 // Generate Random Street Address
-func randomStreetName() string {
+func (g *Generator) randomStreetName() string {
 	// List of last names of all U.S. Presidents, including duplicates
 	lastNames := []string{
 		"Washington", "Adams", "Jefferson", "Madison", "Monroe",
@@ -195,69 +222,71 @@ func randomStreetName() string {
 		"Pike",       // Pike
 	}
 
-	streetName := lastNames[rand.Intn(41)]
-	streetNumber := strconv.Itoa(rand.Intn(9999) + 1)
-	streetType := streetTypes[rand.Intn(24)]
+	streetName := lastNames[g.Intn(41)]
+	streetNumber := strconv.Itoa(g.Intn(9999) + 1)
+	streetType := streetTypes[g.Intn(24)]
 
 	return streetNumber + " " + streetName + " " + streetType
 
 }
 
 // Generate a random SSN in the format XXX-XX-XXXX
-func randomSSN() string {
-	return fmt.Sprintf("%03d-%02d-%04d", rand.Intn(900)+100, rand.Intn(100), rand.Intn(10000))
+func (g *Generator) randomSSN() string {
+	return fmt.Sprintf("%03d-%02d-%04d", g.Intn(900)+100, g.Intn(100), g.Intn(10000))
 }
 
 // Generate a random 10-digit North American phone number in the format (XXX) XXX-XXXX
-func randomPhoneNumber() string {
-	areaCode := rand.Intn(800) + 200 // Avoids invalid area codes like 0xx or 1xx
-	exchangeCode := rand.Intn(800) + 200
-	lineNumber := rand.Intn(10000)
+func (g *Generator) randomPhoneNumber() string {
+	areaCode := g.Intn(800) + 200 // Avoids invalid area codes like 0xx or 1xx
+	exchangeCode := g.Intn(800) + 200
+	lineNumber := g.Intn(10000)
 	return fmt.Sprintf("(%03d) %03d-%04d", areaCode, exchangeCode, lineNumber)
 }
 
+// The cities generated for addresses, roughly in order of population, so zipf distributions favour the larger cities.
+var cities = []struct {
+	City      string
+	StateCode string
+}{
+	{"New York", "NY"}, {"Los Angeles", "CA"}, {"Chicago", "IL"}, {"Houston", "TX"}, {"Phoenix", "AZ"},
+	{"Philadelphia", "PA"}, {"San Antonio", "TX"}, {"San Diego", "CA"}, {"Dallas", "TX"}, {"San Jose", "CA"},
+	{"Austin", "TX"}, {"Jacksonville", "FL"}, {"Fort Worth", "TX"}, {"Columbus", "OH"}, {"Charlotte", "NC"},
+	{"Indianapolis", "IN"}, {"San Francisco", "CA"}, {"Seattle", "WA"}, {"Denver", "CO"},
+	{"Washington", "DC"}, {"Boston", "MA"}, {"El Paso", "TX"}, {"Nashville", "TN"}, {"Detroit", "MI"},
+	{"Oklahoma City", "OK"}, {"Portland", "OR"}, {"Las Vegas", "NV"}, {"Memphis", "TN"}, {"Louisville", "KY"},
+	{"Baltimore", "MD"}, {"Milwaukee", "WI"}, {"Albuquerque", "NM"}, {"Tucson", "AZ"}, {"Fresno", "CA"},
+	{"Mesa", "AZ"}, {"Sacramento", "CA"}, {"Atlanta", "GA"}, {"Kansas City", "MO"}, {"Colorado Springs", "CO"},
+	{"Miami", "FL"}, {"Raleigh", "NC"}, {"Omaha", "NE"}, {"Long Beach", "CA"}, {"Virginia Beach", "VA"},
+	{"Oakland", "CA"}, {"Minneapolis", "MN"}, {"Tulsa", "OK"}, {"Tampa", "FL"}, {"Arlington", "TX"},
+	{"New Orleans", "LA"}, {"Wichita", "KS"}, {"Cleveland", "OH"}, {"Bakersfield", "CA"}, {"Aurora", "CO"},
+	{"Anaheim", "CA"}, {"Honolulu", "HI"}, {"Santa Ana", "CA"}, {"Riverside", "CA"}, {"Corpus Christi", "TX"},
+	{"Lexington", "KY"}, {"Stockton", "CA"}, {"Henderson", "NV"}, {"Saint Paul", "MN"}, {"St. Louis", "MO"},
+	{"Cincinnati", "OH"}, {"Pittsburgh", "PA"}, {"Greensboro", "NC"}, {"Anchorage", "AK"}, {"Plano", "TX"},
+	{"Lincoln", "NE"}, {"Orlando", "FL"}, {"Irvine", "CA"}, {"Newark", "NJ"}, {"Toledo", "OH"}, {"Durham", "NC"},
+	{"Chula Vista", "CA"}, {"Fort Wayne", "IN"}, {"Jersey City", "NJ"}, {"St. Petersburg", "FL"},
+	{"Laredo", "TX"}, {"Madison", "WI"}, {"Chandler", "AZ"}, {"Buffalo", "NY"}, {"Lubbock", "TX"},
+	{"Scottsdale", "AZ"}, {"Reno", "NV"}, {"Glendale", "AZ"}, {"Gilbert", "AZ"}, {"Winston–Salem", "NC"},
+	{"North Las Vegas", "NV"}, {"Norfolk", "VA"}, {"Chesapeake", "VA"}, {"Garland", "TX"}, {"Irving", "TX"},
+	{"Hialeah", "FL"}, {"Fremont", "CA"}, {"Boise", "ID"}, {"Richmond", "VA"}, {"Baton Rouge", "LA"},
+	{"Spokane", "WA"}, {"Des Moines", "IA"}, {"Tacoma", "WA"}, {"San Bernardino", "CA"}, {"Modesto", "CA"},
+	{"Fontana", "CA"}, {"Santa Clarita", "CA"}, {"Birmingham", "AL"}, {"Oxnard", "CA"}, {"Fayetteville", "NC"},
+	{"Rochester", "NY"}, {"Moreno Valley", "CA"}, {"Glendale", "CA"}, {"Yonkers", "NY"}, {"Huntington Beach", "CA"},
+	{"Aurora", "IL"}, {"Salt Lake City", "UT"}, {"Amari", "TX"}, {"Montgomery", "AL"}, {"Little Rock", "AR"},
+	{"Akron", "OH"}, {"Columbus", "GA"}, {"Augusta", "GA"}, {"Grand Rapids", "MI"}, {"Shreveport", "LA"},
+	{"Overland Park", "KS"}, {"Tallahassee", "FL"}, {"Mobile", "AL"}, {"Knoxville", "TN"}, {"Worcester", "MA"},
+	{"Tempe", "AZ"}, {"Cape Coral", "FL"}, {"Providence", "RI"}, {"Fort Lauderdale", "FL"}, {"Chattanooga", "TN"},
+	{"Sioux Falls", "SD"}, {"Brownsville", "TX"}, {"Vancouver", "WA"}, {"Peoria", "AZ"}, {"New Haven", "CT"},
+	{"Pasadena", "TX"}, {"McKinney", "TX"}, {"Mesquite", "TX"}, {"Savannah", "GA"}, {"Syracuse", "NY"}, {"Frisco", "TX"},
+	{"Torrance", "CA"}, {"Bridgeport", "CT"}, {"McAllen", "TX"}, {"Midland", "TX"}, {"Bellevue", "WA"},
+	{"Clearwater", "FL"}, {"Manchester", "NH"}, {"Topeka", "KS"}, {"Elgin", "IL"}, {"West Valley City", "UT"},
+	{"Evansville", "IN"}, {"Abilene", "TX"}, {"Norman", "OK"},
+}
+
 // Generate a random US address with city, stateCode, and zipCode
-func RandomCityState() map[string]string {
-	cities := []struct {
-		City      string
-		StateCode string
-	}{
-		{"New York", "NY"}, {"Los Angeles", "CA"}, {"Chicago", "IL"}, {"Houston", "TX"}, {"Phoenix", "AZ"},
-		{"Philadelphia", "PA"}, {"San Antonio", "TX"}, {"San Diego", "CA"}, {"Dallas", "TX"}, {"San Jose", "CA"},
-		{"Austin", "TX"}, {"Jacksonville", "FL"}, {"Fort Worth", "TX"}, {"Columbus", "OH"}, {"Charlotte", "NC"},
-		{"Indianapolis", "IN"}, {"San Francisco", "CA"}, {"Seattle", "WA"}, {"Denver", "CO"},
-		{"Washington", "DC"}, {"Boston", "MA"}, {"El Paso", "TX"}, {"Nashville", "TN"}, {"Detroit", "MI"},
-		{"Oklahoma City", "OK"}, {"Portland", "OR"}, {"Las Vegas", "NV"}, {"Memphis", "TN"}, {"Louisville", "KY"},
-		{"Baltimore", "MD"}, {"Milwaukee", "WI"}, {"Albuquerque", "NM"}, {"Tucson", "AZ"}, {"Fresno", "CA"},
-		{"Mesa", "AZ"}, {"Sacramento", "CA"}, {"Atlanta", "GA"}, {"Kansas City", "MO"}, {"Colorado Springs", "CO"},
-		{"Miami", "FL"}, {"Raleigh", "NC"}, {"Omaha", "NE"}, {"Long Beach", "CA"}, {"Virginia Beach", "VA"},
-		{"Oakland", "CA"}, {"Minneapolis", "MN"}, {"Tulsa", "OK"}, {"Tampa", "FL"}, {"Arlington", "TX"},
-		{"New Orleans", "LA"}, {"Wichita", "KS"}, {"Cleveland", "OH"}, {"Bakersfield", "CA"}, {"Aurora", "CO"},
-		{"Anaheim", "CA"}, {"Honolulu", "HI"}, {"Santa Ana", "CA"}, {"Riverside", "CA"}, {"Corpus Christi", "TX"},
-		{"Lexington", "KY"}, {"Stockton", "CA"}, {"Henderson", "NV"}, {"Saint Paul", "MN"}, {"St. Louis", "MO"},
-		{"Cincinnati", "OH"}, {"Pittsburgh", "PA"}, {"Greensboro", "NC"}, {"Anchorage", "AK"}, {"Plano", "TX"},
-		{"Lincoln", "NE"}, {"Orlando", "FL"}, {"Irvine", "CA"}, {"Newark", "NJ"}, {"Toledo", "OH"}, {"Durham", "NC"},
-		{"Chula Vista", "CA"}, {"Fort Wayne", "IN"}, {"Jersey City", "NJ"}, {"St. Petersburg", "FL"},
-		{"Laredo", "TX"}, {"Madison", "WI"}, {"Chandler", "AZ"}, {"Buffalo", "NY"}, {"Lubbock", "TX"},
-		{"Scottsdale", "AZ"}, {"Reno", "NV"}, {"Glendale", "AZ"}, {"Gilbert", "AZ"}, {"Winston–Salem", "NC"},
-		{"North Las Vegas", "NV"}, {"Norfolk", "VA"}, {"Chesapeake", "VA"}, {"Garland", "TX"}, {"Irving", "TX"},
-		{"Hialeah", "FL"}, {"Fremont", "CA"}, {"Boise", "ID"}, {"Richmond", "VA"}, {"Baton Rouge", "LA"},
-		{"Spokane", "WA"}, {"Des Moines", "IA"}, {"Tacoma", "WA"}, {"San Bernardino", "CA"}, {"Modesto", "CA"},
-		{"Fontana", "CA"}, {"Santa Clarita", "CA"}, {"Birmingham", "AL"}, {"Oxnard", "CA"}, {"Fayetteville", "NC"},
-		{"Rochester", "NY"}, {"Moreno Valley", "CA"}, {"Glendale", "CA"}, {"Yonkers", "NY"}, {"Huntington Beach", "CA"},
-		{"Aurora", "IL"}, {"Salt Lake City", "UT"}, {"Amari", "TX"}, {"Montgomery", "AL"}, {"Little Rock", "AR"},
-		{"Akron", "OH"}, {"Columbus", "GA"}, {"Augusta", "GA"}, {"Grand Rapids", "MI"}, {"Shreveport", "LA"},
-		{"Overland Park", "KS"}, {"Tallahassee", "FL"}, {"Mobile", "AL"}, {"Knoxville", "TN"}, {"Worcester", "MA"},
-		{"Tempe", "AZ"}, {"Cape Coral", "FL"}, {"Providence", "RI"}, {"Fort Lauderdale", "FL"}, {"Chattanooga", "TN"},
-		{"Sioux Falls", "SD"}, {"Brownsville", "TX"}, {"Vancouver", "WA"}, {"Peoria", "AZ"}, {"New Haven", "CT"},
-		{"Pasadena", "TX"}, {"McKinney", "TX"}, {"Mesquite", "TX"}, {"Savannah", "GA"}, {"Syracuse", "NY"}, {"Frisco", "TX"},
-		{"Torrance", "CA"}, {"Bridgeport", "CT"}, {"McAllen", "TX"}, {"Midland", "TX"}, {"Bellevue", "WA"},
-		{"Clearwater", "FL"}, {"Manchester", "NH"}, {"Topeka", "KS"}, {"Elgin", "IL"}, {"West Valley City", "UT"},
-		{"Evansville", "IN"}, {"Abilene", "TX"}, {"Norman", "OK"},
-	}
+func (g *Generator) RandomCityState() map[string]string {
 
 	// Select a random city-state pair
-	cityState := cities[rand.Intn(len(cities))]
+	cityState := cities[citySampler.pick(g)]
 
 	return map[string]string{
 		"city":      cityState.City,
@@ -266,17 +295,19 @@ func RandomCityState() map[string]string {
 }
 
 // Generate a random US address with city, stateCode, and zipCode
-func randomAddress() map[string]string {
+func (g *Generator) randomAddress() AddressData {
 
-	cityState := RandomCityState()
+	cityState := g.RandomCityState()
 
 	// Generate a random ZIP code in US format (5 digits)
-	zipCode := fmt.Sprintf("%05d", rand.Intn(100000))
-	cityState["zipCode"] = zipCode
-
-	cityState["street"] = randomStreetName()
+	zipCode := fmt.Sprintf("%05d", g.Intn(100000))
 
-	return cityState
+	return AddressData{
+		City:      cityState["city"],
+		StateCode: cityState["stateCode"],
+		ZipCode:   zipCode,
+		Street:    g.randomStreetName(),
+	}
 }
 
 // DateOfBirth holds a date and the corresponding age.
@@ -286,23 +317,23 @@ type DateOfBirth struct {
 }
 
 // Generate a primary date of birth for an adult aged 21-90
-func generatePrimaryDOB() (DateOfBirth, error) {
-	age := rand.Intn(70) + 21 // Random age between 21 and 90
-	year := time.Now().Year() - age
-	month := rand.Intn(12) + 1
-	day := rand.Intn(28) + 1 // Simplify by assuming 28 days in every month
+func (g *Generator) generatePrimaryDOB() (DateOfBirth, error) {
+	age := g.Intn(70) + 21 // Random age between 21 and 90
+	year := g.now.Year() - age
+	month := g.Intn(12) + 1
+	day := g.Intn(28) + 1 // Simplify by assuming 28 days in every month
 
 	dob := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
 	return DateOfBirth{DOB: dob, Age: age}, nil
 }
 
 // Generate a spouse's date of birth based on the primary's age
-func generateSpouseDOB(primaryAge int) (DateOfBirth, error) {
+func (g *Generator) generateSpouseDOB(primaryAge int) (DateOfBirth, error) {
 	if primaryAge < 21 || primaryAge > 90 {
 		return DateOfBirth{}, fmt.Errorf("primary age out of range: %d", primaryAge)
 	}
 
-	ageOffset := rand.Intn(21) - 10 // Random offset between -10 and +10
+	ageOffset := g.Intn(21) - 10 // Random offset between -10 and +10
 	spouseAge := primaryAge + ageOffset
 
 	// Ensure the spouse's age is between 20 and 95
@@ -312,16 +343,16 @@ func generateSpouseDOB(primaryAge int) (DateOfBirth, error) {
 		spouseAge = 95
 	}
 
-	year := time.Now().Year() - spouseAge
-	month := rand.Intn(12) + 1
-	day := rand.Intn(28) + 1
+	year := g.now.Year() - spouseAge
+	month := g.Intn(12) + 1
+	day := g.Intn(28) + 1
 
 	dob := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
 	return DateOfBirth{DOB: dob, Age: spouseAge}, nil
 }
 
 // Generate a child's date of birth based on the primary's age
-func generateChildDOB(primaryAge int) (DateOfBirth, error) {
+func (g *Generator) generateChildDOB(primaryAge int) (DateOfBirth, error) {
 	if primaryAge < 21 || primaryAge > 90 {
 		return DateOfBirth{}, fmt.Errorf("primary age out of range: %d", primaryAge)
 	}
@@ -340,17 +371,17 @@ func generateChildDOB(primaryAge int) (DateOfBirth, error) {
 		return DateOfBirth{}, fmt.Errorf("primary age does not support having a child under these constraints")
 	}
 
-	childAge := rand.Intn(maxChildAge + 1) // Random age between 0 and 24
-	childBirthYear := time.Now().Year() - childAge
+	childAge := g.Intn(maxChildAge + 1) // Random age between 0 and 24
+	childBirthYear := g.now.Year() - childAge
 
 	// Ensure the primary was between 21 and 40 when the child was born
-	for childBirthYear < (time.Now().Year()-primaryAge+primaryYoungestParentAge) || childBirthYear > (time.Now().Year()-primaryAge+primaryOldestParentAge) {
-		childAge = rand.Intn(maxChildAge + 1) // Random age between 0 and 24
-		childBirthYear = time.Now().Year() - childAge
+	for childBirthYear < (g.now.Year()-primaryAge+primaryYoungestParentAge) || childBirthYear > (g.now.Year()-primaryAge+primaryOldestParentAge) {
+		childAge = g.Intn(maxChildAge + 1) // Random age between 0 and 24
+		childBirthYear = g.now.Year() - childAge
 	}
 
-	month := rand.Intn(12) + 1
-	day := rand.Intn(28) + 1
+	month := g.Intn(12) + 1
+	day := g.Intn(28) + 1
 
 	dob := time.Date(childBirthYear, time.Month(month), day, 0, 0, 0, 0, time.UTC)
 	return DateOfBirth{DOB: dob, Age: childAge}, nil
@@ -0,0 +1,132 @@
+package loaderservice
+
+import (
+	"pipeline_blog/appconfig"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// dataColls are every collection a data load may write, whatever the schema variant.
+var dataColls = []string{"Profiles", "Devices", "Mappings", "DeviceBuckets"}
+
+// schemaLayout describes how a schema variant stores a profile's devices.
+type schemaLayout struct {
+	deviceSNs  bool //Profiles hold an array of their devices' serial numbers
+	deviceRefs bool //Profiles hold the serial number and name of each device
+	embedded   bool //Profiles embed their full device documents
+	devices    bool //Devices are written to the Devices collection
+	mappings   bool //Each profile to device link is written to the Mappings collection
+	buckets    bool //Each profile's devices are written in buckets to the DeviceBuckets collection
+}
+
+var schemaLayouts = map[string]schemaLayout{
+	appconfig.SchemaSuperset:          {deviceSNs: true, deviceRefs: true, devices: true, mappings: true},
+	appconfig.SchemaNormalized:        {devices: true, mappings: true},
+	appconfig.SchemaReferenced:        {deviceSNs: true, devices: true},
+	appconfig.SchemaExtendedReference: {deviceRefs: true, devices: true},
+	appconfig.SchemaEmbedded:          {embedded: true},
+	appconfig.SchemaBucketed:          {buckets: true},
+}
+
+func currentLayout() schemaLayout {
+	return schemaLayouts[appconfig.ConfigData.Schema()]
+}
+
+// Collections returns the collections written for the configured schema variant.
+func Collections() []string {
+
+	layout := currentLayout()
+	colls := []string{"Profiles"}
+	if layout.devices {
+		colls = append(colls, "Devices")
+	}
+	if layout.mappings {
+		colls = append(colls, "Mappings")
+	}
+	if layout.buckets {
+		colls = append(colls, "DeviceBuckets")
+	}
+	return colls
+}
+
+// normalizedProfile is a Profile without any device fields, for the variants holding the devices elsewhere.
+type normalizedProfile struct {
+	PersonFields  `bson:",inline"`
+	ContactFields `bson:",inline"`
+}
+
+// referencedProfile is a Profile referencing its devices by serial number alone.
+type referencedProfile struct {
+	PersonFields  `bson:",inline"`
+	DeviceSNs     []string `bson:"deviceSNs"`
+	ContactFields `bson:",inline"`
+}
+
+// extendedReferenceProfile is a Profile referencing its devices by serial number and name.
+type extendedReferenceProfile struct {
+	PersonFields  `bson:",inline"`
+	Devices       []DeviceData `bson:"devices"`
+	ContactFields `bson:",inline"`
+}
+
+// embeddedProfile is a Profile with its full device documents in place of the references to them.
+type embeddedProfile struct {
+	PersonFields  `bson:",inline"`
+	Devices       []Device `bson:"devices"`
+	ContactFields `bson:",inline"`
+}
+
+// DeviceBucket is a document in the DeviceBuckets collection, holding up to DeviceBucketSize of a profile's devices.
+type DeviceBucket struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	ProfileID string             `bson:"profileID"`
+	Bucket    int                `bson:"bucket"` //Position of the bucket among the profile's buckets, from 0
+	Count     int                `bson:"count"`
+	Devices   []Device           `bson:"devices"`
+}
+
+// profileDoc returns the document stored for a profile with the given devices. Every profile stored by a variant
+// has the same fields, so a profile without devices has empty device arrays.
+func (l schemaLayout) profileDoc(profile Profile, devices []Device) interface{} {
+
+	switch {
+	case l.embedded:
+		return embeddedProfile{profile.PersonFields, withoutIDs(devices), profile.ContactFields}
+	case l.deviceSNs && l.deviceRefs:
+		return profile
+	case l.deviceSNs:
+		return referencedProfile{profile.PersonFields, profile.DeviceSNs, profile.ContactFields}
+	case l.deviceRefs:
+		return extendedReferenceProfile{profile.PersonFields, profile.Devices, profile.ContactFields}
+	default:
+		return normalizedProfile{profile.PersonFields, profile.ContactFields}
+	}
+}
+
+// deviceBuckets splits a profile's devices into DeviceBuckets documents.
+func (g *Generator) deviceBuckets(profileID string, devices []Device) []interface{} {
+
+	size := appconfig.ConfigData.DeviceBucket()
+	var buckets []interface{}
+	for start := 0; start < len(devices); start += size {
+		bucketDevices := withoutIDs(devices[start:min(start+size, len(devices))])
+		buckets = append(buckets, DeviceBucket{
+			ID:        g.objectID(),
+			ProfileID: profileID,
+			Bucket:    len(buckets),
+			Count:     len(bucketDevices),
+			Devices:   bucketDevices,
+		})
+	}
+	return buckets
+}
+
+// withoutIDs returns a copy of the devices without their _ids, for embedding in another document.
+func withoutIDs(devices []Device) []Device {
+	embedded := make([]Device, len(devices))
+	for i, device := range devices {
+		device.ID = primitive.NilObjectID
+		embedded[i] = device
+	}
+	return embedded
+}
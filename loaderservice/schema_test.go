@@ -0,0 +1,49 @@
+package loaderservice
+
+import (
+	"reflect"
+	"testing"
+
+	"pipeline_blog/appconfig"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestProfileDocFields(t *testing.T) {
+
+	profileFields := func(devices ...string) []string {
+		return append(append([]string{"lastName", "firstName", "DOB", "SSN", "accountNum", "profileID"}, devices...), "contact", "customerType")
+	}
+	want := map[string][]string{
+		appconfig.SchemaSuperset:          profileFields("deviceSNs", "devices"),
+		appconfig.SchemaNormalized:        profileFields(),
+		appconfig.SchemaReferenced:        profileFields("deviceSNs"),
+		appconfig.SchemaExtendedReference: profileFields("devices"),
+		appconfig.SchemaEmbedded:          profileFields("devices"),
+		appconfig.SchemaBucketed:          profileFields(),
+	}
+	g := newSeededGenerator(1, SeededReferenceTime, "test")
+	for _, variant := range appconfig.SchemaVariants {
+		//A profile without devices still has every field its variant stores, with empty arrays
+		profile, _ := g.GenerateProfile("Ray", "1", "P", "ACCOUNT", g.randomAddress(), 0, nil, nil)
+		profile.ID = [12]byte{}
+		data, err := bson.Marshal(schemaLayouts[variant].profileDoc(profile, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc bson.D
+		if err := bson.Unmarshal(data, &doc); err != nil {
+			t.Fatal(err)
+		}
+		var fields []string
+		for _, elem := range doc {
+			fields = append(fields, elem.Key)
+			if array, ok := elem.Value.(bson.A); ok && len(array) != 0 || elem.Value == nil {
+				t.Errorf("%s: field %s = %v, want an empty array", variant, elem.Key, elem.Value)
+			}
+		}
+		if !reflect.DeepEqual(fields, want[variant]) {
+			t.Errorf("%s: profile fields = %q, want %q", variant, fields, want[variant])
+		}
+	}
+}
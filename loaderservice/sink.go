@@ -0,0 +1,64 @@
+package loaderservice
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// documentSink stores the documents generated by the data load workers. It must be safe for concurrent use.
+type documentSink interface {
+	//write stores a batch of documents generated for the named collection, returning the number stored
+	write(ctx context.Context, collName string, docs []interface{}) (int, error)
+	//checkpoint records that every profile in a worker's range before completedThrough has been stored
+	checkpoint(ctx context.Context, rangeID string, completedThrough int) error
+}
+
+// mongoSink writes the generated documents to MongoDB, checkpointing each worker's progress.
+type mongoSink struct {
+	mdb      *mongo.Database
+	collOpts options.CollectionOptions
+	resumed  bool //Skip the documents a resumed load has already written
+}
+
+func newMongoSink(mdb *mongo.Database, resumed bool) *mongoSink {
+	//Use Write Concern 1 for faster performance. Not recommended for a production system
+	sink := &mongoSink{mdb: mdb, resumed: resumed}
+	sink.collOpts.WriteConcern = writeconcern.W1()
+	return sink
+}
+
+func (s *mongoSink) write(ctx context.Context, collName string, docs []interface{}) (int, error) {
+	err := insertMany(ctx, s.mdb.Collection(collName, &s.collOpts), docs, options.InsertMany())
+	if err == nil {
+		return len(docs), nil
+	}
+	//The load being resumed may have stopped part way through writing this batch. The documents it
+	//wrote are regenerated with the same _ids, so they are rejected as duplicates and can be skipped.
+	if skipped, ok := alreadyWritten(err); ok && s.resumed {
+		return len(docs) - skipped, nil
+	}
+	return 0, err
+}
+
+// alreadyWritten reports whether an insert failed only because some of the documents had the _ids of
+// documents already in the collection, and if so how many.
+func alreadyWritten(err error) (int, bool) {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return 0, false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return 0, false
+		}
+	}
+	return len(bulkErr.WriteErrors), true
+}
+
+func (s *mongoSink) checkpoint(ctx context.Context, rangeID string, completedThrough int) error {
+	return saveCheckpoint(ctx, s.mdb, rangeID, completedThrough)
+}
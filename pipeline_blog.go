@@ -2,47 +2,282 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 
 	"pipeline_blog/appconfig"
 	"pipeline_blog/common"
 
 	"pipeline_blog/loaderservice"
+	"pipeline_blog/reportservice"
 	"pipeline_blog/testservice"
 
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// usage describes the program modes. Without a mode, the program loads data and runs the tests as configured.
+const usage = "Usage: pipeline_blog [flags] [export | import | list-configs | compare <base run> <target run>]"
+
+// modeArgs holds the number of arguments following each program mode.
+var modeArgs = map[string]int{"": 0, "export": 0, "import": 0, "list-configs": 0, "compare": 2}
+
+// parseMode returns the program mode named by the arguments remaining after the flags, checking that it is
+// a known mode and is followed by the right number of arguments.
+func parseMode(args []string) (string, error) {
+
+	mode, modeArgCount := "", 0
+	if len(args) > 0 {
+		mode, modeArgCount = args[0], len(args)-1
+	}
+	want, ok := modeArgs[mode]
+	if !ok {
+		return "", fmt.Errorf("unknown mode %q\n%s", mode, usage)
+	}
+	if modeArgCount != want {
+		return "", fmt.Errorf("%s mode takes %d arguments, got %d\n%s", mode, want, modeArgCount, usage)
+	}
+	return mode, nil
+}
+
+// run executes the program, returning the process exit code. Errors are logged here, once any deferred
+// cleanup has run, so a failure never leaves connections open or indexes unhidden.
+func run() int {
 
 	//The following will load environment variables from a .env file in the application root folder if one exists.
 	godotenv.Load()
 
-	mongoDBURI := os.Getenv("MONGODB_URI")
-	mongoDBName := os.Getenv("MONGODB_DB_NAME")
-	mongoConfigColl := os.Getenv("MONGODB_CONFIG_COLL")
+	//Read the config settings from the config file, environment variables and command line flags
+	if err := appconfig.Load(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		log.Print(err)
+		return 1
+	}
+	//Check the mode before connecting, so a mistyped mode can't fall through to a data load
+	mode, err := parseMode(appconfig.Args)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
 
-	mongoDB := common.GetMongoDatabase(mongoDBURI, mongoDBName)
+	//Ctrl-C or SIGTERM cancels ctx, stopping the run cleanly. A second signal exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+			log.Print("Interrupted - stopping the run and saving partial results. Interrupt again to exit immediately.")
+		case <-finished:
+		}
+	}()
+
+	//Export mode writes the generated data set to files, so it runs without connecting to MongoDB
+	if mode == "export" {
+		if err := exportData(ctx); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	if appconfig.ConfigData.MongoDBURI == "" {
+		log.Print("No MongoDB connection URI configured - set MONGODB_URI or use the -uri flag")
+		return 1
+	}
+
+	mongoDB, err := common.GetMongoDatabase(ctx, appconfig.ConfigData.MongoDBURI, appconfig.ConfigData.DBName)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
 	defer func() {
-		if err := mongoDB.Client().Disconnect(context.TODO()); err != nil {
-			log.Fatal(err)
+		cleanupCtx, cancel := common.CleanupContext(ctx)
+		defer cancel()
+		if err := mongoDB.Client().Disconnect(cleanupCtx); err != nil {
+			log.Printf("Failed to disconnect from MongoDB: %v", err)
 		}
 	}()
-	//Read the config data from MDB
-	_, err := appconfig.ReadConfigMDB(mongoDB, mongoConfigColl)
+	//List mode prints the config documents available to choose from, without reading one
+	if mode == "list-configs" {
+		if appconfig.ConfigData.ConfigColl == "" {
+			log.Print("No config collection configured - set MONGODB_CONFIG_COLL or use the -config-coll flag")
+			return 1
+		}
+		if err := listConfigs(ctx, mongoDB, appconfig.ConfigData.ConfigColl); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	//Merge in the config data from MDB, if a config collection was specified
+	var configJSON string
+	if appconfig.ConfigData.ConfigColl != "" {
+		configJSON, err = appconfig.ReadConfigMDB(ctx, mongoDB, appconfig.ConfigData.ConfigColl)
+	} else if err = appconfig.ConfigData.Validate(); err == nil {
+		configJSON, err = appconfig.EffectiveJSON()
+	}
 	if err != nil {
-		msg := "Streaming Service Pipeline tester encountered an unexpected result reading config data from MDB: " + err.Error()
-		log.Fatal(msg)
+		msg := "Streaming Service Pipeline tester encountered an unexpected result reading config data: " + err.Error()
+		log.Print(msg)
+		return 1
+	}
+	log.Printf("Effective configuration:\n%s", configJSON)
+	if err := loaderservice.ConfigureDistributions(); err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	//Compare mode reports the differences between two sets of results rather than running anything
+	if mode == "compare" {
+		if err := compareRuns(ctx, mongoDB, appconfig.Args[1], appconfig.Args[2]); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
 	}
 
-	//drop the results collection from prior runs
-	mongoDB.Collection(appconfig.ConfigData.ResultsColl).Drop(context.TODO())
+	//Results are appended to those from prior runs - each run is identified by its run ID
+	common.StartRun()
+	log.Printf("Run ID: %s", common.RunID.Hex())
 
-	if appconfig.ConfigData.ReloadData {
-		loaderservice.LoadData()
+	//Import mode loads an exported data set in place of generating one
+	if mode == "import" {
+		if err := loaderservice.ImportData(ctx); err != nil {
+			log.Print(err)
+			return 1
+		}
+	} else if appconfig.ConfigData.ReloadData {
+		if err := loaderservice.LoadData(ctx); err != nil {
+			log.Print(err)
+			return 1
+		}
 	}
 	if appconfig.ConfigData.RunTests {
-		testservice.RunPerformanceTests()
+		if err := testservice.RunPerformanceTests(ctx); err != nil {
+			log.Print(err)
+			return 1
+		}
+		//Fail the run if performance has regressed from the baseline
+		if appconfig.ConfigData.BaselineRunID != "" {
+			regressed, err := checkRegressions(ctx, mongoDB)
+			if err != nil {
+				log.Print(err)
+				return 1
+			}
+			if regressed {
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// exportData validates the configuration for export mode and writes the generated data set to files. The
+// settings are read from the config file, environment variables and command line flags only.
+func exportData(ctx context.Context) error {
+
+	if err := appconfig.ConfigData.ValidateExport(); err != nil {
+		return err
+	}
+	if err := loaderservice.ConfigureDistributions(); err != nil {
+		return err
+	}
+	configJSON, err := appconfig.EffectiveJSON()
+	if err != nil {
+		return err
+	}
+	log.Printf("Effective configuration:\n%s", configJSON)
+	return loaderservice.ExportData(ctx)
+}
+
+func listConfigs(ctx context.Context, mongoDB *mongo.Database, configColl string) error {
+
+	configs, err := appconfig.ListConfigs(ctx, mongoDB, configColl)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Name\tDBName\tConnections\tGoRoutines\tProfiles\tTestRuns\t")
+	for _, cfg := range configs {
+		name := cfg.Name
+		if name == "" {
+			name = "(unnamed)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t\n", name, cfg.DBName, cfg.Connections, cfg.GoRoutines, cfg.Profiles, cfg.TestRuns)
+	}
+	return tw.Flush()
+}
+
+func compareRuns(ctx context.Context, mongoDB *mongo.Database, baseSpec, targetSpec string) error {
+
+	base, err := reportservice.LoadRun(ctx, mongoDB, baseSpec)
+	if err != nil {
+		return err
+	}
+	target, err := reportservice.LoadRun(ctx, mongoDB, targetSpec)
+	if err != nil {
+		return err
+	}
+	comparisons := reportservice.CompareRuns(base, target)
+	if len(comparisons) == 0 {
+		return fmt.Errorf("no pipeline tests in common between %s and %s", baseSpec, targetSpec)
+	}
+	return reportservice.WriteComparison(os.Stdout, base, target, comparisons)
+}
+
+// checkRegressions compares this run's results with the baseline run and reports whether any pipeline regressed.
+func checkRegressions(ctx context.Context, mongoDB *mongo.Database) (bool, error) {
+
+	base, err := reportservice.LoadRun(ctx, mongoDB, appconfig.ConfigData.BaselineRunID)
+	if err != nil {
+		return false, err
+	}
+	//An interrupted baseline holds partial results, so comparing against it could hide a regression
+	if base.Aborted {
+		return false, fmt.Errorf("baseline run %s was aborted - choose a run that completed", appconfig.ConfigData.BaselineRunID)
+	}
+	target, err := reportservice.LoadRun(ctx, mongoDB, common.RunID.Hex())
+	if err != nil {
+		return false, err
+	}
+	//A gate that compared nothing must not pass
+	comparisons := reportservice.CompareRuns(base, target)
+	if len(comparisons) == 0 {
+		return false, fmt.Errorf("no pipeline tests in common between baseline run %s and this run", appconfig.ConfigData.BaselineRunID)
+	}
+	if err := reportservice.WriteComparison(os.Stdout, base, target, comparisons); err != nil {
+		return false, err
+	}
+	if missing := reportservice.MissingTests(base, target); len(missing) > 0 {
+		return false, fmt.Errorf("pipeline tests in baseline run %s were not run: %s", appconfig.ConfigData.BaselineRunID, strings.Join(missing, ", "))
+	}
+	threshold := appconfig.ConfigData.RegressionThreshold
+	if threshold == 0 {
+		threshold = reportservice.DefaultRegressionThreshold
+	}
+	regressions := reportservice.FindRegressions(comparisons, threshold)
+	for _, regression := range regressions {
+		log.Printf("Performance regression: %s", regression)
+	}
+	if len(regressions) == 0 {
+		log.Printf("No pipeline regressed by more than %.1f%% against baseline run %s", threshold, appconfig.ConfigData.BaselineRunID)
 	}
+	return len(regressions) > 0, nil
 }
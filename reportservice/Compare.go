@@ -0,0 +1,251 @@
+package reportservice
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+
+	"pipeline_blog/appconfig"
+	"pipeline_blog/common"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// significanceT is the Welch's t statistic above which a difference in mean iteration time is reported as
+// significant - roughly a 95% confidence level for the sample sizes used by the performance tests.
+const significanceT = 1.96
+
+// TestSummary holds the figures from a results document used to compare runs.
+type TestSummary struct {
+	TestName        string               `bson:"TestName"`
+	Duration        int                  `bson:"Duration"`
+	DurationMicros  int64                `bson:"DurationMicros"`
+	InstanceAverage float64              `bson:"InstanceAverage"`
+	InstanceCount   int                  `bson:"InstanceCount"`
+	Iterations      int                  `bson:"Iterations"`
+	LatencyStats    *common.LatencyStats `bson:"LatencyStats"`
+	Aborted         bool                 `bson:"Aborted"` //The test was interrupted, so only some iterations were executed
+}
+
+// Throughput returns the number of pipeline iterations completed per second.
+func (s TestSummary) Throughput() float64 {
+	micros := s.DurationMicros
+	if micros == 0 {
+		//Results written before microsecond timings were recorded
+		micros = int64(s.Duration) * 1000
+	}
+	if micros == 0 {
+		return 0
+	}
+	return float64(s.iterations()) / (float64(micros) / 1e6)
+}
+
+func (s TestSummary) iterations() int {
+	if s.LatencyStats != nil {
+		return s.LatencyStats.Count
+	}
+	if s.InstanceCount > 0 {
+		return s.InstanceCount
+	}
+	//Results written before iteration counts were recorded
+	return s.Iterations
+}
+
+// RunSummary holds the results of each pipeline test in a run, in the order the tests were run.
+type RunSummary struct {
+	Label   string
+	Tests   []TestSummary
+	Aborted bool //The run was interrupted, or failed, before its data load or every pipeline test completed
+}
+
+func (r RunSummary) lookup(testName string) (TestSummary, bool) {
+	for _, test := range r.Tests {
+		if test.TestName == testName {
+			return test, true
+		}
+	}
+	return TestSummary{}, false
+}
+
+// LoadRun reads the pipeline test results for a run. The run is specified as either a run ID in the configured
+// results collection, a collection name and run ID separated by "/", or just a collection name. When only a
+// collection is given, the most recent complete results for each test in that collection are used - results
+// marked Aborted are only read as part of the run that wrote them.
+func LoadRun(ctx context.Context, mdb *mongo.Database, spec string) (RunSummary, error) {
+
+	ctx, cancel := common.OperationContext(ctx)
+	defer cancel()
+
+	collName := appconfig.ConfigData.ResultsColl
+	runIDHex := spec
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		collName, runIDHex = spec[:i], spec[i+1:]
+	} else if !primitive.IsValidObjectID(spec) {
+		collName, runIDHex = spec, ""
+	}
+
+	filter := bson.D{}
+	if runIDHex != "" {
+		runID, err := primitive.ObjectIDFromHex(runIDHex)
+		if err != nil {
+			return RunSummary{}, fmt.Errorf("invalid run ID %q: %w", runIDHex, err)
+		}
+		filter = bson.D{{"RunID", runID}}
+	} else {
+		filter = bson.D{{"Aborted", bson.D{{"$ne", true}}}}
+	}
+	pipeline := mongo.Pipeline{
+		bson.D{{"$match", filter}},
+		bson.D{{"$sort", bson.D{{"StartTime", -1}}}},
+		bson.D{{"$group", bson.D{
+			{"_id", "$TestName"},
+			{"doc", bson.D{{"$first", bson.D{
+				{"TestName", "$TestName"},
+				{"StartTime", "$StartTime"},
+				{"Duration", "$Duration"},
+				{"DurationMicros", "$DurationMicros"},
+				{"InstanceAverage", "$InstanceAverage"},
+				{"InstanceCount", "$InstanceCount"},
+				{"Iterations", bson.D{{"$size", bson.D{{"$ifNull", bson.A{"$InstanceResults", bson.A{}}}}}}},
+				{"LatencyStats", "$LatencyStats"},
+				{"Aborted", "$Aborted"},
+			}}}},
+		}}},
+		bson.D{{"$replaceWith", "$doc"}},
+		bson.D{{"$sort", bson.D{{"StartTime", 1}}}},
+	}
+	cursor, err := mdb.Collection(collName).Aggregate(ctx, pipeline)
+	if err != nil {
+		return RunSummary{}, fmt.Errorf("failed to read results for %s: %w", spec, err)
+	}
+	var tests []TestSummary
+	if err := cursor.All(ctx, &tests); err != nil {
+		return RunSummary{}, fmt.Errorf("failed to decode results for %s: %w", spec, err)
+	}
+
+	run := RunSummary{Label: spec}
+	for _, test := range tests {
+		run.Aborted = run.Aborted || test.Aborted
+		//Skip the data load, and any test that did not complete an iteration
+		if test.iterations() > 0 {
+			run.Tests = append(run.Tests, test)
+		}
+	}
+	if len(run.Tests) == 0 {
+		return RunSummary{}, fmt.Errorf("no pipeline test results found for %s", spec)
+	}
+	return run, nil
+}
+
+// Comparison gives the change in performance of a pipeline test between two runs.
+type Comparison struct {
+	TestName         string
+	Base             TestSummary
+	Target           TestSummary
+	ThroughputChange float64 //Percentage change in iterations per second
+	MeanChange       float64 //Percentage change in mean iteration time
+	P50Change        float64
+	P90Change        float64
+	P95Change        float64
+	P99Change        float64
+	P999Change       float64
+	HasStats         bool    //False if either run lacks latency stats, in which case only throughput and mean are compared
+	T                float64 //Welch's t statistic for the difference in mean iteration time
+	Significant      bool
+}
+
+// CompareRuns compares each pipeline test found in both runs.
+func CompareRuns(base, target RunSummary) []Comparison {
+
+	var comparisons []Comparison
+	for _, baseTest := range base.Tests {
+		targetTest, ok := target.lookup(baseTest.TestName)
+		if !ok {
+			continue
+		}
+		c := Comparison{
+			TestName:         baseTest.TestName,
+			Base:             baseTest,
+			Target:           targetTest,
+			ThroughputChange: percentChange(baseTest.Throughput(), targetTest.Throughput()),
+			MeanChange:       percentChange(baseTest.InstanceAverage, targetTest.InstanceAverage),
+		}
+		if baseTest.LatencyStats != nil && targetTest.LatencyStats != nil {
+			b, t := baseTest.LatencyStats, targetTest.LatencyStats
+			c.HasStats = true
+			c.MeanChange = percentChange(b.Mean, t.Mean)
+			c.P50Change = percentChange(b.P50, t.P50)
+			c.P90Change = percentChange(b.P90, t.P90)
+			c.P95Change = percentChange(b.P95, t.P95)
+			c.P99Change = percentChange(b.P99, t.P99)
+			c.P999Change = percentChange(b.P999, t.P999)
+			c.T = welchT(*b, *t)
+			c.Significant = math.Abs(c.T) > significanceT
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
+
+// WriteComparison writes a table of the changes between two runs. Tests interrupted in either run are marked
+// as aborted, as their figures cover only the iterations executed.
+func WriteComparison(w io.Writer, base, target RunSummary, comparisons []Comparison) error {
+
+	fmt.Fprintf(w, "Base:   %s\nTarget: %s\n\n", base.Label, target.Label)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Pipeline\tBase it/s\tTarget it/s\tThroughput\tMean\tP50\tP90\tP95\tP99\tP99.9\tSignificant\t")
+	for _, c := range comparisons {
+		percentiles := "n/a\tn/a\tn/a\tn/a\tn/a"
+		significant := "n/a"
+		if c.HasStats {
+			percentiles = strings.Join([]string{
+				formatChange(c.P50Change), formatChange(c.P90Change), formatChange(c.P95Change),
+				formatChange(c.P99Change), formatChange(c.P999Change),
+			}, "\t")
+			significant = "no"
+			if c.Significant {
+				significant = "yes"
+			}
+			significant = fmt.Sprintf("%s (t=%.2f)", significant, c.T)
+		}
+		name := c.TestName
+		if c.Base.Aborted || c.Target.Aborted {
+			name += " (aborted)"
+		}
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%s\t%s\t%s\t%s\t\n",
+			name, c.Base.Throughput(), c.Target.Throughput(), formatChange(c.ThroughputChange),
+			formatChange(c.MeanChange), percentiles, significant)
+	}
+	return tw.Flush()
+}
+
+func percentChange(base, target float64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return (target - base) / base * 100
+}
+
+func formatChange(change float64) string {
+	return fmt.Sprintf("%+.1f%%", change)
+}
+
+// welchT calculates Welch's t statistic for the difference between the mean iteration times of two tests.
+func welchT(base, target common.LatencyStats) float64 {
+	if base.Count < 2 || target.Count < 2 {
+		return 0
+	}
+	//LatencyStats holds the population standard deviation - convert to the sample variance
+	baseVar := base.StdDev * base.StdDev * float64(base.Count) / float64(base.Count-1)
+	targetVar := target.StdDev * target.StdDev * float64(target.Count) / float64(target.Count-1)
+	stdErr := math.Sqrt(baseVar/float64(base.Count) + targetVar/float64(target.Count))
+	if stdErr == 0 {
+		return 0
+	}
+	return (target.Mean - base.Mean) / stdErr
+}
@@ -0,0 +1,75 @@
+package reportservice
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"pipeline_blog/common"
+)
+
+func TestWelchT(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		base, target common.LatencyStats
+		want         float64
+	}{
+		{
+			name:   "too few iterations",
+			base:   common.LatencyStats{Count: 1, Mean: 1},
+			target: common.LatencyStats{Count: 10, Mean: 5, StdDev: 1},
+			want:   0,
+		},
+		{
+			name:   "no variance",
+			base:   common.LatencyStats{Count: 10, Mean: 1},
+			target: common.LatencyStats{Count: 10, Mean: 2},
+			want:   0,
+		},
+		{
+			//Sample variances of 2, so a standard error of sqrt(2/2 + 2/2)
+			name:   "equal counts",
+			base:   common.LatencyStats{Count: 2, Mean: 1, StdDev: 1},
+			target: common.LatencyStats{Count: 2, Mean: 3, StdDev: 1},
+			want:   math.Sqrt2,
+		},
+		{
+			//Sample variances of 10 and 5, so a standard error of sqrt(10/10 + 5/5)
+			name:   "unequal counts and variances",
+			base:   common.LatencyStats{Count: 10, Mean: 10, StdDev: 3},
+			target: common.LatencyStats{Count: 5, Mean: 14, StdDev: 2},
+			want:   4 / math.Sqrt2,
+		},
+		{
+			name:   "faster target",
+			base:   common.LatencyStats{Count: 5, Mean: 14, StdDev: 2},
+			target: common.LatencyStats{Count: 10, Mean: 10, StdDev: 3},
+			want:   -4 / math.Sqrt2,
+		},
+	}
+	for _, test := range tests {
+		if got := welchT(test.base, test.target); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: welchT() = %g, want %g", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWriteComparisonMarksAborted(t *testing.T) {
+
+	complete := TestSummary{TestName: "complete", DurationMicros: 1e6, InstanceCount: 10}
+	interrupted := TestSummary{TestName: "interrupted", DurationMicros: 1e6, InstanceCount: 3, Aborted: true}
+	base := RunSummary{Label: "base", Tests: []TestSummary{complete, {TestName: "interrupted", DurationMicros: 1e6, InstanceCount: 10}}}
+	target := RunSummary{Label: "target", Tests: []TestSummary{complete, interrupted}, Aborted: true}
+
+	var out strings.Builder
+	if err := WriteComparison(&out, base, target, CompareRuns(base, target)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "interrupted (aborted)") {
+		t.Errorf("aborted test not marked:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "complete (aborted)") {
+		t.Errorf("complete test marked as aborted:\n%s", out.String())
+	}
+}
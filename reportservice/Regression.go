@@ -0,0 +1,45 @@
+package reportservice
+
+import "fmt"
+
+// DefaultRegressionThreshold is the percentage change treated as a regression when none is configured.
+const DefaultRegressionThreshold = 10.0
+
+// Regression describes a pipeline test that performed worse than the baseline by more than the threshold.
+type Regression struct {
+	TestName string
+	Metric   string
+	Change   float64 //Percentage change from the baseline
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: %s changed by %s", r.TestName, r.Metric, formatChange(r.Change))
+}
+
+// MissingTests returns the names of the pipeline tests in the base run that have no results in the target run.
+func MissingTests(base, target RunSummary) []string {
+
+	var missing []string
+	for _, baseTest := range base.Tests {
+		if _, ok := target.lookup(baseTest.TestName); !ok {
+			missing = append(missing, baseTest.TestName)
+		}
+	}
+	return missing
+}
+
+// FindRegressions returns every comparison where throughput fell, or the 95th percentile iteration time
+// rose, by more than threshold percent.
+func FindRegressions(comparisons []Comparison, threshold float64) []Regression {
+
+	var regressions []Regression
+	for _, c := range comparisons {
+		if c.ThroughputChange < -threshold {
+			regressions = append(regressions, Regression{TestName: c.TestName, Metric: "throughput", Change: c.ThroughputChange})
+		}
+		if c.HasStats && c.P95Change > threshold {
+			regressions = append(regressions, Regression{TestName: c.TestName, Metric: "P95", Change: c.P95Change})
+		}
+	}
+	return regressions
+}
@@ -2,15 +2,15 @@ package testservice
 
 import (
 	"context"
-	"os"
+	"errors"
+	"fmt"
 
 	"log"
+	"strings"
 
 	"sync"
 	"time"
 
-	"math/rand"
-
 	"pipeline_blog/appconfig"
 	"pipeline_blog/common"
 	"pipeline_blog/loaderservice"
@@ -19,52 +19,80 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func RunPerformanceTests() {
+// RunPerformanceTests runs each registered pipeline design in turn, saving the results of each to MongoDB.
+// Any index unhidden for a test is hidden again before it returns, even if a test fails. If ctx is cancelled
+// the current test stops, and the iterations executed so far are saved in a result marked as aborted.
+func RunPerformanceTests(ctx context.Context) (err error) {
 
-	mongoDBURI := os.Getenv("MONGODB_URI")
+	mongoDBURI := appconfig.ConfigData.MongoDBURI
 	//Connection to the primary node - use this to manipulate indexes etc.
-	mdb := common.GetMongoDatabase(mongoDBURI, os.Getenv("MONGODB_DB_NAME"))
+	mdb, err := common.GetMongoDatabase(ctx, mongoDBURI, appconfig.ConfigData.DBName)
+	if err != nil {
+		return err
+	}
+	defer disconnect(ctx, mdb)
 	//Direct connections to each node in the cluster. Use these to spread search load across the nodes.
 	mongoDirectURIS, err := common.GenerateDirectConnectionStrings(mongoDBURI)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to find the replica set nodes: %w", err)
 	}
-	mongoDBName := os.Getenv("MONGODB_DB_NAME")
+	mongoDBName := appconfig.ConfigData.DBName
 
 	connectionCount := appconfig.ConfigData.Connections
 	routineCount := appconfig.ConfigData.GoRoutines
 	testRuns := appconfig.ConfigData.TestRuns
 
+	//Register the date-driven pipeline designs if a date range has been configured
+	if appconfig.ConfigData.DateRangeDays > 0 {
+		if err := registerDatePipelines(ctx, mdb, appconfig.ConfigData.DateRangeDays); err != nil {
+			return err
+		}
+	}
+
+	//Register any pipeline designs defined in Extended JSON files
+	if appconfig.ConfigData.PipelineDir != "" {
+		if err := LoadPipelineFiles(appconfig.ConfigData.PipelineDir); err != nil {
+			return err
+		}
+	}
+
+	//Every pipeline design is run with the same sequence of parameters
+	schedule, err := LoadSchedule(appconfig.ConfigData.ParameterFile, testRuns)
+	if err != nil {
+		return err
+	}
+
 	//Seed the cache on each replica set:
 	log.Print("Seeding cache on each replica set node")
 	var seedConnections []*mongo.Database
+	defer func() {
+		for _, seedConn := range seedConnections {
+			disconnect(ctx, seedConn)
+		}
+	}()
 	for _, node := range mongoDirectURIS {
 		//We use direct connections to each node in the replica set in a round-robin
 		//allocation. That should spread the load accross all the nodes in the replica set
-		db := common.GetMongoDatabase(node, mongoDBName)
+		db, err := common.GetMongoDatabase(ctx, node, mongoDBName)
+		if err != nil {
+			return err
+		}
 		seedConnections = append(seedConnections, db)
 	}
-	defer func() {
-		for _, seedConn := range seedConnections {
-			seedConn.Client().Disconnect(context.TODO())
-		}
-	}()
-	common.MasterWG.Add(3 * len(seedConnections))
-	for _, seedConn := range seedConnections {
-		go common.SeedCollection(seedConn, &common.MasterWG, "Profiles")
-		go common.SeedCollection(seedConn, &common.MasterWG, "Mappings")
-		go common.SeedCollection(seedConn, &common.MasterWG, "Devices")
+	if err := seedCache(ctx, seedConnections); err != nil {
+		return err
 	}
-	common.MasterWG.Wait()
 	log.Print("Cache seeding complete")
 
-	//Work out the number of testRuns to be executed by each connection.
-	connectionRunCount := testRuns / connectionCount
-
 	//Create the necessary number of Mongo Client / Database connections
 	//Use direct connections so we can spread the read load accross the replica set
 
 	var connections []*mongo.Database
+	defer func() {
+		for _, conn := range connections {
+			disconnect(ctx, conn)
+		}
+	}()
 
 	nodes := len(mongoDirectURIS)
 	currNode := 0
@@ -72,18 +100,14 @@ func RunPerformanceTests() {
 	for i := 0; i < connectionCount; i++ {
 		//We use direct connections to each node in the replica set in a round-robin
 		//allocation. That should spread the load accross all the nodes in the replica set
-		db := common.GetMongoDatabase(mongoDirectURIS[currNode], mongoDBName)
+		db, err := common.GetMongoDatabase(ctx, mongoDirectURIS[currNode], mongoDBName)
+		if err != nil {
+			return err
+		}
 		connections = append(connections, db)
 		currNode++
 		currNode = currNode % nodes
 	}
-	defer func() {
-		for i := 0; i < connectionCount; i++ {
-			if err := connections[i].Client().Disconnect(context.TODO()); err != nil {
-				log.Fatal(err)
-			}
-		}
-	}()
 
 	//Create an array of sub-wait groups - one for each MDB connection
 	var wgs []*sync.WaitGroup
@@ -92,215 +116,206 @@ func RunPerformanceTests() {
 		wgs = append(wgs, &wg)
 	}
 
-	//Run Original Pipeline Tests
-	//Initialize the master wait group
-	common.MasterWG.Add(connectionCount)
-	//Unhide the index used by this pipeline (and make sure we hide it again once we're done)
-	common.HideIndex("contact.address.city_1", "Profiles", mdb, false)
-	defer common.HideIndex("contact.address.city_1", "Profiles", mdb, true)
-	//Create the results document for this sequence of tests
-	common.CreateResultDoc(mdb, "originalPipeline")
-	//Start a new Go Routine for each MDB connection
-	startTime := time.Now()
-	for i := 0; i < connectionCount; i++ {
-		go runTests(i, connections[i], mdb, wgs[i], routineCount, connectionRunCount, "originalPipeline")
+	//Index the instances collection so the results of each test can be summarised once it completes
+	if appconfig.ConfigData.SeparateInstanceResults {
+		indexModel := mongo.IndexModel{
+			Keys: bson.D{
+				{"RunID", 1},
+				{"TestName", 1},
+			},
+		}
+		if err := common.CreateIndex(ctx, mdb.Collection(appconfig.ConfigData.InstanceResultsColl()), indexModel); err != nil {
+			return err
+		}
 	}
-	common.MasterWG.Wait()
-	endTime := time.Now()
-	//Save the execution duration back to MongoDB
-	common.SaveDuration(startTime, endTime, mdb, "originalPipeline")
-	log.Print("Original Pipeline tests completed")
-
-	//Run no-unwinds pipeline tests
-	//Initialize the master wait group
-	common.MasterWG.Add(connectionCount)
-	//Create the results document for this sequence of tests
-	common.CreateResultDoc(mdb, "noUnwinds")
-	//Start a new Go Routine for each MDB connection
-	startTime = time.Now()
-	for i := 0; i < connectionCount; i++ {
-		go runTests(i, connections[i], mdb, wgs[i], routineCount, connectionRunCount, "noUnwinds")
+
+	//Make sure the index used by the last test is hidden again however we return, so the cluster is left as we found it
+	visibleIndex := ""
+	defer func() {
+		if visibleIndex == "" {
+			return
+		}
+		cleanupCtx, cancel := common.CleanupContext(ctx)
+		defer cancel()
+		if hideErr := common.HideIndex(cleanupCtx, visibleIndex, "Profiles", mdb, true); hideErr != nil {
+			err = errors.Join(err, hideErr)
+		}
+	}()
+
+	//Run each registered pipeline design in turn, skipping those designed for a different schema variant
+	schema := appconfig.ConfigData.Schema()
+	for _, test := range Pipelines.Tests() {
+		if !test.SupportsSchema(schema) {
+			log.Printf("Skipping %s - it is designed for the %s schema variants", test.Name, strings.Join(test.Schemas, ", "))
+			continue
+		}
+		//Unhide the index used by this pipeline, hiding the one used by the previous pipeline
+		if test.IndexName != visibleIndex {
+			if visibleIndex != "" {
+				if err := common.HideIndex(ctx, visibleIndex, "Profiles", mdb, true); err != nil {
+					return err
+				}
+				visibleIndex = ""
+			}
+			if test.IndexName != "" {
+				if err := common.HideIndex(ctx, test.IndexName, "Profiles", mdb, false); err != nil {
+					return err
+				}
+				visibleIndex = test.IndexName
+			}
+		}
+		//Create the results document for this sequence of tests
+		if err := common.CreateResultDoc(ctx, mdb, test.Name); err != nil {
+			return err
+		}
+		if test.ReseedCache {
+			//Reseed the collections with the new index on the profiles collection active
+			log.Print("Cache reseeding started")
+			if err := seedCache(ctx, seedConnections); err != nil {
+				return err
+			}
+			log.Print("Cache reseeding complete")
+		}
+
+		//Initialize the master wait group
+		common.MasterWG.Add(connectionCount)
+		//Iteration results are buffered in memory and only written once the tests have finished
+		sink := common.NewResultSink(mdb, test.Name)
+		var testErrs common.WorkerErrors
+		//Start a new Go Routine for each MDB connection
+		startTime := time.Now()
+		for i := 0; i < connectionCount; i++ {
+			//Work out the part of the parameter schedule to be executed by this connection.
+			go runTests(ctx, i, connections[i], mdb, wgs[i], routineCount, schedule.partition(connectionCount, i), test, sink, &testErrs)
+		}
+		common.MasterWG.Wait()
+		endTime := time.Now()
+		//Save the iteration results and execution duration back to MongoDB - even if the tests did not all complete
+		testErr := testErrs.Err()
+		aborted := testErr != nil || ctx.Err() != nil
+		if err := saveResults(ctx, mdb, sink, test.Name, startTime, endTime, aborted); err != nil {
+			return err
+		}
+		if testErr != nil {
+			return fmt.Errorf("%s tests failed: %w", test.Name, testErr)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s tests interrupted: %w", test.Name, ctx.Err())
+		}
+		log.Printf("%s tests completed", test.Name)
 	}
-	common.MasterWG.Wait()
-	endTime = time.Now()
-	//Save the execution duration back to MongoDB
-	common.SaveDuration(startTime, endTime, mdb, "noUnwinds")
-	log.Print("No unwind tests completed")
-
-	//Run no-mapping collection pipeline tests
-	//Initialize the master wait group
-	common.MasterWG.Add(connectionCount)
-	//Create the results document for this sequence of tests
-	common.CreateResultDoc(mdb, "noMapping")
-	//Start a new Go Routine for each MDB connection
-	startTime = time.Now()
-	for i := 0; i < connectionCount; i++ {
-		go runTests(i, connections[i], mdb, wgs[i], routineCount, connectionRunCount, "noMapping")
+	return nil
+}
+
+// saveResults writes the results of a test to its results document. The results of an aborted test are still
+// saved, flagged as aborted, so the work is written even once ctx has been cancelled.
+func saveResults(ctx context.Context, mdb *mongo.Database, sink *common.ResultSink, testName string, startTime, endTime time.Time, aborted bool) error {
+
+	ctx = context.WithoutCancel(ctx)
+	if err := sink.Close(ctx); err != nil {
+		return err
 	}
-	common.MasterWG.Wait()
-	endTime = time.Now()
-	//Save the execution duration back to MongoDB
-	common.SaveDuration(startTime, endTime, mdb, "noMapping")
-	common.HideIndex("contact.address.city_1", "Profiles", mdb, true)
-	log.Print("No mapping tests completed")
-
-	//Run duplicate group_ids pipeline tests
-	//Create the results document for this sequence of tests
-	common.CreateResultDoc(mdb, "duplicateDeviceNames")
-	//Unhide the index used by this pipeline (and make sure we hide it again once we're done)
-	common.HideIndex("contact.address.city_1_devices.deviceName_1", "Profiles", mdb, false)
-	defer common.HideIndex("contact.address.city_1_devices.deviceName_1", "Profiles", mdb, true)
-
-	//Reseed the collections with the new index on the prifuiles collection active
-	log.Print("Cache reseeding started")
-	common.MasterWG.Add(3 * len(seedConnections))
-	for _, seedConn := range seedConnections {
-		go common.SeedCollection(seedConn, &common.MasterWG, "Profiles")
-		go common.SeedCollection(seedConn, &common.MasterWG, "Mappings")
-		go common.SeedCollection(seedConn, &common.MasterWG, "Devices")
+	if err := common.SaveDuration(ctx, startTime, endTime, mdb, testName); err != nil {
+		return err
 	}
-	common.MasterWG.Wait()
-	log.Print("Cache reseeding complete")
-
-	//Initialize the master wait group
-	common.MasterWG.Add(connectionCount)
-	//Start a new Go Routine for each MDB connection
-	startTime = time.Now()
-	for i := 0; i < connectionCount; i++ {
-		go runTests(i, connections[i], mdb, wgs[i], routineCount, connectionRunCount, "duplicateDeviceNames")
+	//Calculate the latency percentiles and histogram for the individual iterations
+	if err := common.SaveLatencyStats(ctx, mdb, testName); err != nil {
+		return err
 	}
-	common.MasterWG.Wait()
-	endTime = time.Now()
-	//Save the execution duration back to MongoDB
-	common.SaveDuration(startTime, endTime, mdb, "duplicateDeviceNames")
-	common.HideIndex("contact.address.city_1_devices.deviceName_1", "Profiles", mdb, true)
-	log.Print("Duplicate device name tests completed")
-
-	//Run index sort pipeline tests
-	//Create the results document for this sequence of tests
-	common.CreateResultDoc(mdb, "indexSort")
-	//Unhide the index used by this pipeline (and make sure we hide it again once we're done)
-	common.HideIndex("contact.address.city_1_devices.deviceName_1_profileID_1", "Profiles", mdb, false)
-	defer common.HideIndex("contact.address.city_1_devices.deviceName_1_profileID_1", "Profiles", mdb, true)
-	//Reseed the collections with the new index on the prifuiles collection active
-	log.Print("Cache reseeding started")
-	common.MasterWG.Add(3 * len(seedConnections))
-	for _, seedConn := range seedConnections {
-		go common.SeedCollection(seedConn, &common.MasterWG, "Profiles")
-		go common.SeedCollection(seedConn, &common.MasterWG, "Mappings")
-		go common.SeedCollection(seedConn, &common.MasterWG, "Devices")
+	if aborted {
+		return common.MarkAborted(ctx, mdb, testName)
 	}
-	common.MasterWG.Wait()
-	log.Print("Cache reseeding complete")
+	return nil
+}
 
-	//Initialize the master wait group
-	common.MasterWG.Add(connectionCount)
-	//Start a new Go Routine for each MDB connection
-	startTime = time.Now()
-	for i := 0; i < connectionCount; i++ {
-		go runTests(i, connections[i], mdb, wgs[i], routineCount, connectionRunCount, "indexSort")
+func disconnect(ctx context.Context, mdb *mongo.Database) {
+	ctx, cancel := common.CleanupContext(ctx)
+	defer cancel()
+	if err := mdb.Client().Disconnect(ctx); err != nil {
+		log.Printf("Failed to disconnect from MongoDB: %v", err)
 	}
-	common.MasterWG.Wait()
-	endTime = time.Now()
-	//Save the execution duration back to MongoDB
-	common.SaveDuration(startTime, endTime, mdb, "indexSort")
-	common.HideIndex("contact.address.city_1_devices.deviceName_1_profileID_1", "Profiles", mdb, true)
-	log.Print("Index sort tests completed")
-
 }
 
-func runTests(connectionNum int, mdbread, mdbwrite *mongo.Database, wg *sync.WaitGroup, goRoutines, runCount int, testName string) {
+// seedCache pulls each collection into the cache on every node in the replica set
+func seedCache(ctx context.Context, seedConnections []*mongo.Database) error {
 
-	defer common.MasterWG.Done()
+	var seedErrs common.WorkerErrors
+	common.MasterWG.Add(len(loaderservice.Collections()) * len(seedConnections))
+	for _, seedConn := range seedConnections {
+		for _, collName := range loaderservice.Collections() {
+			go func(seedConn *mongo.Database, collName string) {
+				defer common.MasterWG.Done()
+				seedErrs.Add(common.SeedCollection(ctx, seedConn, collName))
+			}(seedConn, collName)
+		}
+	}
+	common.MasterWG.Wait()
+	return seedErrs.Err()
+}
 
-	//Work out the number of members to be loaded by each goRoutine.
-	routineRunCount := runCount / goRoutines
+func runTests(ctx context.Context, connectionNum int, mdbread, mdbwrite *mongo.Database, wg *sync.WaitGroup, goRoutines int, schedule ParameterSchedule, test PipelineTest, sink *common.ResultSink, errs *common.WorkerErrors) {
 
-	//Seed cache:
+	defer common.MasterWG.Done()
 
 	//Initialize the wait group
 	wg.Add(goRoutines)
 
 	for i := 0; i < goRoutines; i++ {
-		go runPipeline(connectionNum, i, mdbread, mdbwrite, wg, routineRunCount, testName)
+		//Work out the number of test runs to be executed by this goRoutine.
+		go runPipeline(ctx, connectionNum, i, mdbread, mdbwrite, wg, schedule.partition(goRoutines, i), test, sink, errs)
 	}
 	wg.Wait()
 
 }
 
-func runPipeline(connectionNum, routineNum int, mdbread, mdbwrite *mongo.Database, wg *sync.WaitGroup, runCount int, testName string) {
+func runPipeline(ctx context.Context, connectionNum, routineNum int, mdbread, mdbwrite *mongo.Database, wg *sync.WaitGroup, schedule ParameterSchedule, test PipelineTest, sink *common.ResultSink, errs *common.WorkerErrors) {
 
 	defer wg.Done()
 	profileColl := mdbread.Collection("Profiles")
+	runCount := len(schedule)
 
 	for x := 0; x < runCount; x++ {
 
-		cityState := loaderservice.RandomCityState()
-		deviceName := loaderservice.RandomDeviceName(rand.Intn(2) == 1)
-		city := cityState["city"]
-
-		var pipeline mongo.Pipeline = nil
-		switch testName {
-		case "originalPipeline":
-			pipeline = getOrigPipeline(city, deviceName)
-		case "noUnwinds":
-			pipeline = getNoUnwindPipeline(city, deviceName)
-		case "noMapping":
-			pipeline = getNoMappingsPipeline(city, deviceName)
-		case "duplicateDeviceNames":
-			pipeline = getDuplicateDeviceNamesPipeline(city, deviceName)
-		case "indexSort":
-			pipeline = getIndexSortPipeline(city, deviceName)
+		//Stop once the run has been interrupted
+		if ctx.Err() != nil {
+			return
+		}
+
+		city := schedule[x].City
+		deviceName := schedule[x].DeviceName
+
+		//A pipeline defined in a file could fail to parse with some parameters - stop the test cleanly if so
+		pipeline, err := test.Builder(city, deviceName)
+		if err != nil {
+			errs.Add(fmt.Errorf("failed to build pipeline %s for city %q and device %q: %w", test.Name, city, deviceName, err))
+			return
 		}
 		startTime := time.Now()
 		// Run the aggregation
 		if pipeline != nil {
-			cursor, err := profileColl.Aggregate(context.TODO(), pipeline)
-			if err != nil {
-				log.Fatalf("Failed to run aggregation: %v", err)
-			}
-			defer cursor.Close(context.TODO())
-
-			var memberDocs []interface{}
-			err = cursor.All(context.TODO(), &memberDocs)
-			if err != nil {
-				log.Fatalf("Failed to decode aggregation result: %v", err)
+			if err := runAggregation(ctx, profileColl, pipeline); err != nil {
+				//An interrupted aggregation is not a failure - the iteration just isn't recorded
+				if ctx.Err() == nil {
+					errs.Add(err)
+				}
+				return
 			}
 		}
 		endTime := time.Now()
 
-		//Save the execution duration back to MongoDB
+		//Record the execution duration - it is written back to MongoDB once all iterations are complete
 		var result common.InstanceResult
 		result.StartTime = startTime
 		result.EndTime = endTime
-		result.Duration = int(endTime.UnixMilli() - startTime.UnixMilli())
+		duration := endTime.Sub(startTime)
+		result.Duration = int(duration.Milliseconds())
+		result.DurationMicros = duration.Microseconds()
 		result.ConnectionNum = connectionNum + 1
 		result.RoutineNum = routineNum + 1
 		result.City = city
 		result.DeviceName = deviceName
 
-		filter := bson.D{{"TestName", testName}}
-		updates := bson.A{
-			bson.D{
-				{"$set", bson.D{
-					{"InstanceResults", bson.D{
-						{"$concatArrays", bson.A{
-							"$InstanceResults", bson.A{result},
-						}},
-					}},
-				}},
-			},
-			bson.D{
-				{"$set", bson.D{
-					{"InstanceAverage", bson.D{{"$avg", "$InstanceResults.Duration"}}},
-				}},
-			},
-		}
-		resultsColl := mdbwrite.Collection(appconfig.ConfigData.ResultsColl)
-		_, err := resultsColl.UpdateOne(context.TODO(), filter, updates)
-		if err != nil {
-			log.Fatal(err)
-		}
+		sink.Record(result)
 
 		//If this was the last run for connection / goroutine 1, rerun the query and get the explain for it.
 		if connectionNum == 0 && routineNum == 0 && x == (runCount-1) && pipeline != nil {
@@ -315,19 +330,41 @@ func runPipeline(connectionNum, routineNum int, mdbread, mdbwrite *mongo.Databas
 			}
 			//Run the explain command
 			var explainResult bson.M
-			err = mdbwrite.RunCommand(context.TODO(), explainCommand).Decode(&explainResult)
+			opCtx, cancel := common.OperationContext(ctx)
+			defer cancel()
+			err := mdbwrite.RunCommand(opCtx, explainCommand).Decode(&explainResult)
 			if err != nil {
-				log.Fatalf("Failed to get explain plan: %v", err)
+				errs.Add(fmt.Errorf("failed to get explain plan: %w", err))
+				return
 			}
 			//Add the explain plan to the results document
+			filter := common.ResultFilter(test.Name)
 			updates := bson.D{
 				{"$set", bson.D{{"ExplainPlan", explainResult}}},
 			}
 			resultsColl := mdbwrite.Collection(appconfig.ConfigData.ResultsColl)
-			_, err = resultsColl.UpdateOne(context.TODO(), filter, updates)
+			_, err = resultsColl.UpdateOne(opCtx, filter, updates)
 			if err != nil {
-				log.Fatal(err)
+				errs.Add(fmt.Errorf("failed to save explain plan: %w", err))
+				return
 			}
 		}
 	}
 }
+
+// runAggregation executes a pipeline and reads all its results, bounded by the operation timeout.
+func runAggregation(ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline) error {
+
+	ctx, cancel := common.OperationContext(ctx)
+	defer cancel()
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to run aggregation: %w", err)
+	}
+	var memberDocs []interface{}
+	err = cursor.All(ctx, &memberDocs)
+	if err != nil {
+		return fmt.Errorf("failed to decode aggregation result: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package testservice
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"pipeline_blog/loaderservice"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// registerDatePipelines adds the date-driven pipeline designs, which filter the looked up devices by date as well
+// as by name. Their date ranges cover the given number of days either side of the loaded data set's reference time.
+func registerDatePipelines(ctx context.Context, mdb *mongo.Database, days int) error {
+
+	referenceTime, err := loaderservice.ReferenceTime(ctx, mdb)
+	if err != nil {
+		return err
+	}
+	seenSince := referenceTime.AddDate(0, 0, -days)
+	expiresBefore := referenceTime.AddDate(0, 0, days)
+	log.Printf("Date-driven pipelines select devices seen since %s, or expiring before %s", seenSince.Format(time.DateOnly), expiresBefore.Format(time.DateOnly))
+
+	tests := []PipelineTest{
+		{
+			Name: "recentDevices",
+			Builder: builtIn(func(city, deviceName string) mongo.Pipeline {
+				return getDeviceDatePipeline(city, deviceName, bson.E{"lastSeenDate", bson.D{{"$gte", seenSince}}})
+			}),
+			IndexName: "contact.address.city_1_devices.deviceName_1_profileID_1",
+			Schemas:   extendedReferenceSchemas,
+		},
+		{
+			Name: "expiringDevices",
+			Builder: builtIn(func(city, deviceName string) mongo.Pipeline {
+				return getDeviceDatePipeline(city, deviceName, bson.E{"authorizationExpiryDate", bson.D{{"$lt", expiresBefore}}})
+			}),
+			IndexName: "contact.address.city_1_devices.deviceName_1_profileID_1",
+			Schemas:   extendedReferenceSchemas,
+		},
+	}
+	for _, test := range tests {
+		if err := Pipelines.Register(test); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getDeviceDatePipeline is the indexSort pipeline, with the looked up devices further filtered on one of their dates.
+func getDeviceDatePipeline(city, deviceName string, dateFilter bson.E) mongo.Pipeline {
+
+	// Define the aggregation pipeline
+	pipeline := mongo.Pipeline{
+		bson.D{
+			{"$match",
+				bson.D{
+					{"contact.address.city", city},
+					{"devices.deviceName", deviceName},
+				},
+			},
+		},
+		bson.D{{"$skip", 0}},
+		bson.D{{"$limit", 10}},
+		bson.D{
+			{"$lookup",
+				bson.D{
+					{"from", "Devices"},
+					{"localField", "devices.deviceSN"},
+					{"foreignField", "deviceSN"},
+					{"pipeline",
+						bson.A{
+							bson.D{{"$match", bson.D{{"deviceName", deviceName}, dateFilter}}},
+							bson.D{
+								{"$set",
+									bson.D{
+										{"_id", "$$REMOVE"},
+									},
+								},
+							},
+						},
+					},
+					{"as", "deviceData"},
+				},
+			},
+		},
+		bson.D{
+			{"$set",
+				bson.D{
+					{"_id", "$$REMOVE"},
+					{"deviceSNs", "$$REMOVE"},
+					{"devices", "$$REMOVE"},
+					{"mappingData", "$$REMOVE"},
+					{"customerType", "$$REMOVE"},
+				},
+			},
+		},
+	}
+	return pipeline
+
+}
@@ -0,0 +1,79 @@
+package testservice
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// getDeviceBucketsPipeline is the noUnwinds design for the bucketed schema variant. Each profile's device buckets
+// are looked up by profileID, and the matching devices gathered from them into a single array.
+func getDeviceBucketsPipeline(city, deviceName string) mongo.Pipeline {
+
+	// Define the aggregation pipeline
+	pipeline := mongo.Pipeline{
+		bson.D{{"$match", bson.D{{"contact.address.city", city}}}},
+		bson.D{
+			{"$lookup",
+				bson.D{
+					{"from", "DeviceBuckets"},
+					{"localField", "profileID"},
+					{"foreignField", "profileID"},
+					{"pipeline",
+						bson.A{
+							bson.D{{"$match", bson.D{{"devices.deviceName", deviceName}}}},
+							bson.D{
+								{"$project",
+									bson.D{
+										{"_id", 0},
+										{"devices",
+											bson.D{
+												{"$filter",
+													bson.D{
+														{"input", "$devices"},
+														{"cond", bson.D{{"$eq", bson.A{"$$this.deviceName", bson.D{{"$literal", deviceName}}}}}},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+					{"as", "bucketData"},
+				},
+			},
+		},
+		bson.D{{"$match", bson.D{{"bucketData", bson.D{{"$ne", bson.A{}}}}}}},
+		bson.D{
+			{"$set",
+				bson.D{
+					{"deviceData",
+						bson.D{
+							{"$reduce",
+								bson.D{
+									{"input", "$bucketData.devices"},
+									{"initialValue", bson.A{}},
+									{"in", bson.D{{"$concatArrays", bson.A{"$$value", "$$this"}}}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		bson.D{
+			{"$set",
+				bson.D{
+					{"_id", "$$REMOVE"},
+					{"bucketData", "$$REMOVE"},
+					{"customerType", "$$REMOVE"},
+				},
+			},
+		},
+		bson.D{{"$sort", bson.D{{"profileID", 1}}}},
+		bson.D{{"$skip", 0}},
+		bson.D{{"$limit", 10}},
+	}
+	return pipeline
+}
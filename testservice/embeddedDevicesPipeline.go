@@ -0,0 +1,52 @@
+package testservice
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// getEmbeddedDevicesPipeline is the indexSort design for the embedded schema variant. The device data is already
+// in the profile, so the matching devices are filtered from it rather than looked up.
+func getEmbeddedDevicesPipeline(city, deviceName string) mongo.Pipeline {
+
+	// Define the aggregation pipeline
+	pipeline := mongo.Pipeline{
+		bson.D{
+			{"$match",
+				bson.D{
+					{"contact.address.city", city},
+					{"devices.deviceName", deviceName},
+				},
+			},
+		},
+		bson.D{{"$skip", 0}},
+		bson.D{{"$limit", 10}},
+		bson.D{
+			{"$set",
+				bson.D{
+					{"deviceData",
+						bson.D{
+							{"$filter",
+								bson.D{
+									{"input", "$devices"},
+									{"cond", bson.D{{"$eq", bson.A{"$$this.deviceName", bson.D{{"$literal", deviceName}}}}}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		bson.D{
+			{"$set",
+				bson.D{
+					{"_id", "$$REMOVE"},
+					{"devices", "$$REMOVE"},
+					{"customerType", "$$REMOVE"},
+				},
+			},
+		},
+	}
+	return pipeline
+
+}
@@ -0,0 +1,80 @@
+package testservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+
+	"pipeline_blog/common"
+	"pipeline_blog/loaderservice"
+)
+
+// QueryParams holds the parameters substituted into a pipeline for a single test iteration.
+type QueryParams struct {
+	City       string `json:"City"`
+	DeviceName string `json:"DeviceName"`
+}
+
+// ParameterSchedule lists the parameters for every iteration of a pipeline test, in the order they are used.
+// Every pipeline design replays the same schedule, so each is tested against exactly the same workload.
+type ParameterSchedule []QueryParams
+
+// generateSchedule draws the parameters for the given number of iterations. The schedule is reproduced
+// exactly if Seed is set.
+func generateSchedule(iterations int) ParameterSchedule {
+
+	g := loaderservice.NewGenerator("parameters")
+	schedule := make(ParameterSchedule, iterations)
+	for i := range schedule {
+		schedule[i].City = g.RandomCityState()["city"]
+		schedule[i].DeviceName = g.RandomDeviceName(g.Intn(2) == 1)
+	}
+	return schedule
+}
+
+// LoadSchedule returns the parameter schedule for the given number of iterations. If path is set and the file
+// exists, the schedule is read from it, otherwise a new schedule is generated and, if path is set, saved there
+// so later runs can replay it.
+func LoadSchedule(path string, iterations int) (ParameterSchedule, error) {
+
+	if path == "" {
+		return generateSchedule(iterations), nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		schedule := generateSchedule(iterations)
+		data, err := json.MarshalIndent(schedule, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to save parameter schedule: %w", err)
+		}
+		log.Printf("Saved parameter schedule for %d iterations to %s", iterations, path)
+		return schedule, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parameter schedule: %w", err)
+	}
+	var schedule ParameterSchedule
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return nil, fmt.Errorf("parameter schedule %s: %w", path, err)
+	}
+	if len(schedule) < iterations {
+		return nil, fmt.Errorf("parameter schedule %s holds %d iterations, but TestRuns is %d", path, len(schedule), iterations)
+	}
+	log.Printf("Replaying parameter schedule from %s", path)
+	return schedule[:iterations], nil
+}
+
+// partition returns the part of the schedule executed by worker i of the given number of workers.
+func (s ParameterSchedule) partition(workers, i int) ParameterSchedule {
+	start := 0
+	for w := 0; w < i; w++ {
+		start += common.Share(len(s), workers, w)
+	}
+	return s[start : start+common.Share(len(s), workers, i)]
+}
@@ -0,0 +1,55 @@
+package testservice
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestPartition(t *testing.T) {
+
+	schedule := make(ParameterSchedule, 10)
+	for i := range schedule {
+		schedule[i].City = strconv.Itoa(i)
+	}
+	tests := []struct {
+		workers int
+		want    [][]int //Indexes of the iterations given to each worker
+	}{
+		{1, [][]int{{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}}},
+		{3, [][]int{{0, 1, 2, 3}, {4, 5, 6}, {7, 8, 9}}},
+		{4, [][]int{{0, 1, 2}, {3, 4, 5}, {6, 7}, {8, 9}}},
+		{12, [][]int{{0}, {1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}, {9}, {}, {}}},
+	}
+	for _, test := range tests {
+		for i, indexes := range test.want {
+			want := ParameterSchedule{}
+			for _, index := range indexes {
+				want = append(want, schedule[index])
+			}
+			if got := schedule.partition(test.workers, i); !reflect.DeepEqual(got, want) {
+				t.Errorf("partition(%d, %d) = %v, want %v", test.workers, i, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadScheduleReplaysSavedSchedule(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "schedule.json")
+	saved, err := LoadSchedule(path, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := LoadSchedule(path, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, saved[:15]) {
+		t.Errorf("LoadSchedule() replayed %v, want %v", replayed, saved[:15])
+	}
+	if _, err := LoadSchedule(path, 21); err == nil {
+		t.Error("LoadSchedule() replayed a schedule shorter than TestRuns")
+	}
+}
@@ -0,0 +1,116 @@
+package testservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"pipeline_blog/appconfig"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// pipelineFile is the format of a pipeline definition file. A file may alternatively contain just the
+// pipeline array (as exported from Compass), in which case the test is named after the file.
+type pipelineFile struct {
+	TestName    string          `json:"TestName"`
+	IndexName   string          `json:"IndexName"`
+	ReseedCache bool            `json:"ReseedCache"`
+	Schemas     []string        `json:"Schemas"`
+	Pipeline    json.RawMessage `json:"Pipeline"`
+}
+
+// pipelineTemplate wraps the pipeline text so it can be parsed by bson.UnmarshalExtJSON, which expects a document.
+type pipelineTemplate struct {
+	Pipeline mongo.Pipeline `bson:"pipeline"`
+}
+
+// LoadPipelineFiles registers a pipeline test for each Extended JSON (*.json) file in dir, in file name order.
+// The placeholders {{city}} and {{deviceName}} in the file are substituted with the parameters for each iteration.
+func LoadPipelineFiles(dir string) error {
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		test, err := readPipelineFile(path)
+		if err != nil {
+			return fmt.Errorf("pipeline file %s: %w", path, err)
+		}
+		if err := Pipelines.Register(test); err != nil {
+			return fmt.Errorf("pipeline file %s: %w", path, err)
+		}
+		log.Printf("Registered pipeline %s from %s", test.Name, path)
+	}
+	return nil
+}
+
+func readPipelineFile(path string) (PipelineTest, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PipelineTest{}, err
+	}
+	var def pipelineFile
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		def.Pipeline = trimmed
+	} else if err := json.Unmarshal(data, &def); err != nil {
+		return PipelineTest{}, err
+	}
+	if len(def.Pipeline) == 0 {
+		return PipelineTest{}, fmt.Errorf("no Pipeline defined")
+	}
+	for _, schema := range def.Schemas {
+		if !slices.Contains(appconfig.SchemaVariants, schema) {
+			return PipelineTest{}, fmt.Errorf("unknown schema variant %q", schema)
+		}
+	}
+	if def.TestName == "" {
+		def.TestName = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	template := string(def.Pipeline)
+	//Check the pipeline parses before we start timing anything
+	if _, err := buildPipelineFromTemplate(template, "", ""); err != nil {
+		return PipelineTest{}, err
+	}
+
+	return PipelineTest{
+		Name: def.TestName,
+		Builder: func(city, deviceName string) (mongo.Pipeline, error) {
+			return buildPipelineFromTemplate(template, city, deviceName)
+		},
+		IndexName:   def.IndexName,
+		ReseedCache: def.ReseedCache,
+		Schemas:     def.Schemas,
+	}, nil
+}
+
+// buildPipelineFromTemplate substitutes the parameter placeholders into the pipeline text and parses the result.
+func buildPipelineFromTemplate(template, city, deviceName string) (mongo.Pipeline, error) {
+
+	replacer := strings.NewReplacer(
+		"{{city}}", jsonEscape(city),
+		"{{deviceName}}", jsonEscape(deviceName),
+	)
+	var parsed pipelineTemplate
+	extJSON := `{"pipeline": ` + replacer.Replace(template) + `}`
+	if err := bson.UnmarshalExtJSON([]byte(extJSON), false, &parsed); err != nil {
+		return nil, err
+	}
+	return parsed.Pipeline, nil
+}
+
+// jsonEscape escapes a value so it can be substituted inside a JSON string literal.
+func jsonEscape(value string) string {
+	escaped, _ := json.Marshal(value)
+	return string(escaped[1 : len(escaped)-1])
+}
@@ -0,0 +1,58 @@
+package testservice
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// writePipelineFile writes a pipeline definition file for a test.
+func writePipelineFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadPipelineFile(t *testing.T) {
+
+	path := writePipelineFile(t, "cityMatch.json", `[{"$match": {"contact.address.city": "{{city}}", "devices.deviceName": "{{deviceName}}"}}]`)
+	test, err := readPipelineFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if test.Name != "cityMatch" {
+		t.Errorf("test named %q, want cityMatch", test.Name)
+	}
+	//Parameters are escaped, so quotes and backslashes can't break the pipeline
+	city, deviceName := `O"Fallon`, `TV \ "Lounge"`
+	pipeline, err := test.Builder(city, deviceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := pipeline[0][0].Value.(bson.D)
+	if match[0].Value != city || match[1].Value != deviceName {
+		t.Errorf("Builder() = %v, want the parameters %q and %q", pipeline, city, deviceName)
+	}
+}
+
+func TestReadPipelineFileErrors(t *testing.T) {
+
+	tests := []struct {
+		name, contents string
+	}{
+		{"no pipeline", `{"TestName": "empty"}`},
+		{"invalid pipeline", `[{"$match": {"contact.address.city": }}]`},
+		{"unquoted placeholder", `[{"$limit": {{city}}}]`},
+		{"unknown schema", `{"Schemas": ["flat"], "Pipeline": [{"$limit": 1}]}`},
+	}
+	for _, test := range tests {
+		if _, err := readPipelineFile(writePipelineFile(t, "test.json", test.contents)); err == nil {
+			t.Errorf("%s: readPipelineFile() succeeded, want an error", test.name)
+		}
+	}
+}
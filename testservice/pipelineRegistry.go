@@ -0,0 +1,97 @@
+package testservice
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"pipeline_blog/appconfig"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PipelineBuilder returns the aggregation pipeline to execute for a single test iteration.
+type PipelineBuilder func(city, deviceName string) (mongo.Pipeline, error)
+
+// builtIn adapts a function building a pipeline in code, which cannot fail, to a PipelineBuilder.
+func builtIn(build func(city, deviceName string) mongo.Pipeline) PipelineBuilder {
+	return func(city, deviceName string) (mongo.Pipeline, error) {
+		return build(city, deviceName), nil
+	}
+}
+
+// PipelineTest describes a pipeline design to be benchmarked by RunPerformanceTests.
+type PipelineTest struct {
+	Name        string          //Written to the TestName field of the results document
+	Builder     PipelineBuilder //Builds the pipeline for each iteration
+	IndexName   string          //Index on the Profiles collection to unhide while the test runs. Empty for none.
+	ReseedCache bool            //Reseed the cache on each node after unhiding the index, before the test starts
+	Schemas     []string        //Schema variants the pipeline is designed for. Empty for any.
+}
+
+// SupportsSchema reports whether the pipeline can be run against data generated in the given schema variant.
+func (t PipelineTest) SupportsSchema(schema string) bool {
+	return len(t.Schemas) == 0 || slices.Contains(t.Schemas, schema)
+}
+
+// PipelineRegistry holds the pipeline designs to be tested, in the order they were registered.
+type PipelineRegistry struct {
+	tests []PipelineTest
+	names map[string]bool
+}
+
+// The schema variants holding the data used by each of the blog series' pipeline designs
+var (
+	mappedSchemas            = []string{appconfig.SchemaSuperset, appconfig.SchemaNormalized}
+	referencedSchemas        = []string{appconfig.SchemaSuperset, appconfig.SchemaReferenced}
+	extendedReferenceSchemas = []string{appconfig.SchemaSuperset, appconfig.SchemaExtendedReference}
+)
+
+// Pipelines contains every pipeline design RunPerformanceTests will execute.
+var Pipelines PipelineRegistry
+
+func init() {
+	//The pipeline designs from the blog series, in the order they are discussed in the articles. Each can also
+	//be run against the schema variant modelling just the data it uses.
+	mustRegister(PipelineTest{Name: "originalPipeline", Builder: builtIn(getOrigPipeline), IndexName: "contact.address.city_1", Schemas: mappedSchemas})
+	mustRegister(PipelineTest{Name: "noUnwinds", Builder: builtIn(getNoUnwindPipeline), IndexName: "contact.address.city_1", Schemas: mappedSchemas})
+	mustRegister(PipelineTest{Name: "noMapping", Builder: builtIn(getNoMappingsPipeline), IndexName: "contact.address.city_1", Schemas: referencedSchemas})
+	mustRegister(PipelineTest{Name: "duplicateDeviceNames", Builder: builtIn(getDuplicateDeviceNamesPipeline), IndexName: "contact.address.city_1_devices.deviceName_1", ReseedCache: true, Schemas: extendedReferenceSchemas})
+	mustRegister(PipelineTest{Name: "indexSort", Builder: builtIn(getIndexSortPipeline), IndexName: "contact.address.city_1_devices.deviceName_1_profileID_1", ReseedCache: true, Schemas: extendedReferenceSchemas})
+	//Designs for the schema variants that don't keep devices in their own collection
+	mustRegister(PipelineTest{Name: "embeddedDevices", Builder: builtIn(getEmbeddedDevicesPipeline), IndexName: "contact.address.city_1_devices.deviceName_1_profileID_1", ReseedCache: true, Schemas: []string{appconfig.SchemaEmbedded}})
+	mustRegister(PipelineTest{Name: "deviceBuckets", Builder: builtIn(getDeviceBucketsPipeline), IndexName: "contact.address.city_1", Schemas: []string{appconfig.SchemaBucketed}})
+}
+
+func mustRegister(test PipelineTest) {
+	if err := Pipelines.Register(test); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Register adds a pipeline design to the registry. Test names must be unique.
+func (r *PipelineRegistry) Register(test PipelineTest) error {
+
+	if test.Name == "" {
+		return fmt.Errorf("pipeline test name must not be empty")
+	}
+	if test.Builder == nil {
+		return fmt.Errorf("pipeline test %s has no builder", test.Name)
+	}
+	if r.names == nil {
+		r.names = make(map[string]bool)
+	}
+	if r.names[test.Name] {
+		return fmt.Errorf("pipeline test %s is already registered", test.Name)
+	}
+	r.names[test.Name] = true
+	r.tests = append(r.tests, test)
+	return nil
+}
+
+// Tests returns the registered pipeline designs in registration order.
+func (r *PipelineRegistry) Tests() []PipelineTest {
+	tests := make([]PipelineTest, len(r.tests))
+	copy(tests, r.tests)
+	return tests
+}